@@ -21,39 +21,68 @@ import (
 	"os"
 
 	"github.com/openshift/lvm-driver/pkg/lvmdriver"
+	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/tracing"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
 	"k8s.io/klog/v2"
 )
 
 var (
-	endpoint   = flag.String("endpoint", "unix://tmp/csi.sock", "CSI Endpoint")
-	nodeID     = flag.String("nodeid", "", "node id")
-	driverName = flag.String("drivername", "lvm.redhat.com", "name of the driver")
+	endpoint             = flag.String("endpoint", "unix://tmp/csi.sock", "CSI Endpoint")
+	nodeID               = flag.String("nodeid", "", "node id")
+	driverName           = flag.String("drivername", "lvm.redhat.com", "name of the driver")
+	volumeGroup          = flag.String("volume-group", "", "volume group to provision volumes in, enables the controller service")
+	configFile           = flag.String("config", "", "YAML configuration file of the driver, reloaded when it changes")
+	fakeLVM              = flag.Bool("fake-lvm", false, "use an in-memory lvm backend and fake mounts instead of the host's, for development only")
+	copyBandwidth        = flag.Int64("copy-bandwidth", 100<<20, "bytes per second to copy when cloning thick volumes, 0 for unlimited")
+	healthAddress        = flag.String("health-address", svc.DefaultHealthAddress, "address to serve /healthz and /readyz on, empty to disable")
+	metricsAddress       = flag.String("metrics-address", "", "address to serve Prometheus metrics on /metrics, empty to disable unless set by the configuration file")
+	loggingFormat        = flag.String("logging-format", utils.TextLoggingFormat, "format of the log messages, text or json")
+	tracingEndpoint      = flag.String("tracing-endpoint", "", "host:port of the OpenTelemetry collector to export traces to over OTLP gRPC, empty to disable tracing")
+	tracingInsecure      = flag.Bool("tracing-insecure", false, "export traces without TLS")
+	tracingSamplingRatio = flag.Float64("tracing-sampling-ratio", 1, "fraction of the RPCs traced when the caller did not sample them already, from 0 to 1")
 )
 
-func init() {
-	_ = flag.Set("logtostderr", "true")
-}
-
 func main() {
 	klog.InitFlags(nil)
+	// The flag only exists once klog registers it
+	_ = flag.Set("logtostderr", "true")
 	flag.Parse()
 
+	if err := utils.SetLoggingFormat(*loggingFormat, os.Stderr); err != nil {
+		klog.Fatalf("failed to set up logging: %v", err)
+	}
+
 	if *nodeID == "" {
 		klog.Warning("nodeid is empty")
 	}
 
-	driverInit()
-
-	os.Exit(0)
+	code := driverInit()
+	klog.Flush()
+	os.Exit(code)
 }
 
-func driverInit() {
+func driverInit() int {
 	opts := lvmdriver.LvmDriverOptions{
-		NodeID:     *nodeID,
-		DriverName: *driverName,
-		Endpoint:   *endpoint,
+		NodeID:         *nodeID,
+		DriverName:     *driverName,
+		Endpoint:       *endpoint,
+		VolumeGroup:    *volumeGroup,
+		ConfigFile:     *configFile,
+		FakeLVM:        *fakeLVM,
+		CopyBandwidth:  *copyBandwidth,
+		HealthAddress:  *healthAddress,
+		MetricsAddress: *metricsAddress,
+		Tracing: tracing.Options{
+			Endpoint:      *tracingEndpoint,
+			Insecure:      *tracingInsecure,
+			SamplingRatio: *tracingSamplingRatio,
+		},
 	}
 
-	driver := lvmdriver.NewLvmDriver(&opts)
-	driver.Run()
+	driver, err := lvmdriver.NewLvmDriver(&opts)
+	if err != nil {
+		klog.Fatalf("failed to initialize the driver: %v", err)
+	}
+	return driver.Run()
 }
@@ -0,0 +1,254 @@
+package lvm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+)
+
+// Client implements Interface by running the lvm commands on the host
+type Client struct {
+	exec utilexec.Interface
+	// timeout bounds each command, 0 leaves reports to the context of the
+	// call and commands changing volumes unbounded
+	timeout time.Duration
+	// observer is told about every command run, if any
+	observer CommandObserver
+}
+
+// CommandObserver is told about the lvm commands a Client runs, e.g. to
+// record metrics
+type CommandObserver interface {
+	// ObserveCommand is called once command completed after duration, err
+	// is the *CommandError it failed with, if any
+	ObserveCommand(command string, duration time.Duration, err error)
+}
+
+var _ Interface = &Client{}
+
+// Commands are the lvm commands Client runs on the host
+var Commands = []string{"vgs", "pvs", "lvs", "lvcreate", "lvremove", "lvextend", "lvchange"}
+
+func NewClient(exec utilexec.Interface, timeout time.Duration, observer CommandObserver) *Client {
+	return &Client{
+		exec:     exec,
+		timeout:  timeout,
+		observer: observer,
+	}
+}
+
+func (c *Client) ListVolumeGroups(ctx context.Context) ([]*VolumeGroup, error) {
+	out, err := c.report(ctx, "vgs", vgColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVolumeGroups(out)
+}
+
+func (c *Client) GetVolumeGroup(ctx context.Context, name string) (*VolumeGroup, error) {
+	out, err := c.report(ctx, "vgs", vgColumns, name)
+	if err != nil {
+		return nil, err
+	}
+
+	vgs, err := parseVolumeGroups(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vgs) != 1 {
+		return nil, fmt.Errorf("expected a single volume group %s, found %d", name, len(vgs))
+	}
+
+	return vgs[0], nil
+}
+
+func (c *Client) ListPhysicalVolumes(ctx context.Context, vg string) ([]*PhysicalVolume, error) {
+	out, err := c.report(ctx, "pvs", pvColumns, "--select", "vg_name="+vg)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePhysicalVolumes(out)
+}
+
+func (c *Client) ListLogicalVolumes(ctx context.Context, vg string) ([]*LogicalVolume, error) {
+	out, err := c.report(ctx, "lvs", lvColumns, vg)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLogicalVolumes(out)
+}
+
+func (c *Client) GetLogicalVolume(ctx context.Context, vg string, name string) (*LogicalVolume, error) {
+	out, err := c.report(ctx, "lvs", lvColumns, vg+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	lvs, err := parseLogicalVolumes(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lvs) != 1 {
+		return nil, fmt.Errorf("expected a single logical volume %s/%s, found %d", vg, name, len(lvs))
+	}
+
+	return lvs[0], nil
+}
+
+func (c *Client) CreateLogicalVolume(ctx context.Context, opts CreateOptions) error {
+	target := opts.VG
+	args := []string{"--yes", "-n", opts.Name}
+	if opts.Origin != "" {
+		// Snapshots of thin volumes are thin themselves and take no size,
+		// given one lvm creates a copy-on-write snapshot instead
+		target = opts.VG + "/" + opts.Origin
+		args = append(args, "-s")
+		if opts.Size > 0 {
+			args = append(args, "-L", fmt.Sprintf("%db", opts.Size))
+		}
+	} else if opts.Pool != "" {
+		// Thin volumes are given a virtual size and allocated from the pool
+		target = opts.VG + "/" + opts.Pool
+		args = append(args, "-V", fmt.Sprintf("%db", opts.Size), "--thin")
+	} else {
+		args = append(args, "-L", fmt.Sprintf("%db", opts.Size))
+	}
+	args = append(args, opts.ExtraArgs...)
+	for _, tag := range opts.Tags {
+		args = append(args, "--addtag", tag)
+	}
+	args = append(args, target)
+
+	return c.change(ctx, "lvcreate", args...)
+}
+
+func (c *Client) RemoveLogicalVolume(ctx context.Context, vg string, name string) error {
+	return c.change(ctx, "lvremove", "--yes", vg+"/"+name)
+}
+
+func (c *Client) ExtendLogicalVolume(ctx context.Context, vg string, name string, size uint64) error {
+	return c.change(ctx, "lvextend", "-L", fmt.Sprintf("%db", size), vg+"/"+name)
+}
+
+func (c *Client) SetLogicalVolumeActive(ctx context.Context, vg string, name string, active bool) error {
+	args := []string{"-an"}
+	if active {
+		// Thin snapshots and volumes restored from them skip activation unless told otherwise
+		args = []string{"-ay", "-K"}
+	}
+
+	return c.change(ctx, "lvchange", append(args, vg+"/"+name)...)
+}
+
+func (c *Client) UpdateLogicalVolumeTags(ctx context.Context, vg string, name string, add []string, remove []string) error {
+	var args []string
+	for _, tag := range add {
+		args = append(args, "--addtag", tag)
+	}
+	for _, tag := range remove {
+		args = append(args, "--deltag", tag)
+	}
+
+	return c.change(ctx, "lvchange", append(args, vg+"/"+name)...)
+}
+
+// report runs an lvm reporting command with sizes in bytes and JSON output
+func (c *Client) report(ctx context.Context, cmd string, columns string, args ...string) ([]byte, error) {
+	args = append([]string{"--reportformat", "json", "--units", "b", "--nosuffix", "-o", columns}, args...)
+	return c.run(ctx, cmd, args...)
+}
+
+// change runs an lvm command changing volumes. Killing it when the call is
+// cancelled could leave a volume half changed, so it is only bounded by the
+// timeout of the client.
+func (c *Client) change(ctx context.Context, cmd string, args ...string) error {
+	_, err := c.run(detachedContext{parent: ctx}, cmd, args...)
+	return err
+}
+
+// run executes an lvm command and returns its stdout. It logs through the
+// logger of ctx so the command is attributed to the call running it.
+func (c *Client) run(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("running lvm command", "command", cmd, "args", args)
+
+	start := time.Now()
+	out, err := c.runCommand(ctx, cmd, args...)
+	duration := time.Since(start)
+	if c.observer != nil {
+		c.observer.ObserveCommand(cmd, duration, err)
+	}
+
+	if err != nil {
+		logger.V(4).Info("lvm command failed", "command", cmd, "duration", duration, "err", err)
+	} else {
+		logger.V(5).Info("lvm command succeeded", "command", cmd, "duration", duration)
+	}
+
+	return out, err
+}
+
+func (c *Client) runCommand(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := c.exec.CommandContext(ctx, cmd, args...)
+	command.SetStdout(&stdout)
+	command.SetStderr(&stderr)
+
+	if err := command.Run(); err != nil {
+		cmdErr := &CommandError{
+			Command: cmd,
+			Args:    args,
+			Stderr:  sanitizeStderr(stderr.String()),
+			Err:     err,
+		}
+
+		var exitErr utilexec.ExitError
+		if errors.As(err, &exitErr) {
+			cmdErr.ExitCode = exitErr.ExitStatus()
+		}
+
+		return nil, cmdErr
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// detachedContext carries the values of its parent, such as its logger and
+// span, but never expires nor is cancelled along with it
+type detachedContext struct {
+	parent context.Context
+}
+
+var _ context.Context = detachedContext{}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,253 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// fakeCommand records the arguments of a command and answers with the given output
+func fakeCommand(argv *[]string, stdout string, stderr string, err error) testingexec.FakeCommandAction {
+	return func(cmd string, args ...string) exec.Cmd {
+		*argv = append([]string{cmd}, args...)
+		return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+			RunScript: []testingexec.FakeAction{
+				func() ([]byte, []byte, error) { return []byte(stdout), []byte(stderr), err },
+			},
+		}, cmd, args...)
+	}
+}
+
+func TestClientCommands(t *testing.T) {
+	tests := []struct {
+		desc         string
+		run          func(c *Client) error
+		expectedArgv []string
+	}{
+		{
+			desc: "list volume groups",
+			run: func(c *Client) error {
+				_, err := c.ListVolumeGroups(context.Background())
+				return err
+			},
+			expectedArgv: []string{"vgs", "--reportformat", "json", "--units", "b", "--nosuffix", "-o", vgColumns},
+		},
+		{
+			desc: "list physical volumes",
+			run: func(c *Client) error {
+				_, err := c.ListPhysicalVolumes(context.Background(), "vg0")
+				return err
+			},
+			expectedArgv: []string{"pvs", "--reportformat", "json", "--units", "b", "--nosuffix", "-o", pvColumns, "--select", "vg_name=vg0"},
+		},
+		{
+			desc: "list logical volumes",
+			run: func(c *Client) error {
+				_, err := c.ListLogicalVolumes(context.Background(), "vg0")
+				return err
+			},
+			expectedArgv: []string{"lvs", "--reportformat", "json", "--units", "b", "--nosuffix", "-o", lvColumns, "vg0"},
+		},
+		{
+			desc: "create logical volume",
+			run: func(c *Client) error {
+				return c.CreateLogicalVolume(context.Background(), CreateOptions{
+					VG:   "vg0",
+					Name: "pvc-1",
+					Size: 4194304,
+					Tags: []string{"a=1", "b=2"},
+				})
+			},
+			expectedArgv: []string{"lvcreate", "--yes", "-n", "pvc-1", "-L", "4194304b", "--addtag", "a=1", "--addtag", "b=2", "vg0"},
+		},
+		{
+			desc: "create thin logical volume",
+			run: func(c *Client) error {
+				return c.CreateLogicalVolume(context.Background(), CreateOptions{
+					VG:   "vg0",
+					Name: "pvc-1",
+					Size: 4194304,
+					Pool: "pool0",
+					Tags: []string{"a=1"},
+				})
+			},
+			expectedArgv: []string{"lvcreate", "--yes", "-n", "pvc-1", "-V", "4194304b", "--thin", "--addtag", "a=1", "vg0/pool0"},
+		},
+		{
+			desc: "create logical volume with extra arguments",
+			run: func(c *Client) error {
+				return c.CreateLogicalVolume(context.Background(), CreateOptions{
+					VG:        "vg0",
+					Name:      "pvc-1",
+					Size:      4194304,
+					Tags:      []string{"a=1"},
+					ExtraArgs: []string{"--type", "raid1", "-m", "1"},
+				})
+			},
+			expectedArgv: []string{"lvcreate", "--yes", "-n", "pvc-1", "-L", "4194304b", "--type", "raid1", "-m", "1", "--addtag", "a=1", "vg0"},
+		},
+		{
+			desc: "create thin snapshot",
+			run: func(c *Client) error {
+				return c.CreateLogicalVolume(context.Background(), CreateOptions{
+					VG:     "vg0",
+					Name:   "snap-1",
+					Origin: "pvc-1",
+					Tags:   []string{"a=1"},
+				})
+			},
+			expectedArgv: []string{"lvcreate", "--yes", "-n", "snap-1", "-s", "--addtag", "a=1", "vg0/pvc-1"},
+		},
+		{
+			desc: "create copy-on-write snapshot",
+			run: func(c *Client) error {
+				return c.CreateLogicalVolume(context.Background(), CreateOptions{
+					VG:     "vg0",
+					Name:   "snap-1",
+					Size:   4194304,
+					Origin: "pvc-1",
+				})
+			},
+			expectedArgv: []string{"lvcreate", "--yes", "-n", "snap-1", "-s", "-L", "4194304b", "vg0/pvc-1"},
+		},
+		{
+			desc: "remove logical volume",
+			run: func(c *Client) error {
+				return c.RemoveLogicalVolume(context.Background(), "vg0", "pvc-1")
+			},
+			expectedArgv: []string{"lvremove", "--yes", "vg0/pvc-1"},
+		},
+		{
+			desc: "extend logical volume",
+			run: func(c *Client) error {
+				return c.ExtendLogicalVolume(context.Background(), "vg0", "pvc-1", 8388608)
+			},
+			expectedArgv: []string{"lvextend", "-L", "8388608b", "vg0/pvc-1"},
+		},
+		{
+			desc: "activate logical volume",
+			run: func(c *Client) error {
+				return c.SetLogicalVolumeActive(context.Background(), "vg0", "pvc-1", true)
+			},
+			expectedArgv: []string{"lvchange", "-ay", "-K", "vg0/pvc-1"},
+		},
+		{
+			desc: "deactivate logical volume",
+			run: func(c *Client) error {
+				return c.SetLogicalVolumeActive(context.Background(), "vg0", "pvc-1", false)
+			},
+			expectedArgv: []string{"lvchange", "-an", "vg0/pvc-1"},
+		},
+		{
+			desc: "update logical volume tags",
+			run: func(c *Client) error {
+				return c.UpdateLogicalVolumeTags(context.Background(), "vg0", "pvc-1", []string{"a=1"}, []string{"b=2"})
+			},
+			expectedArgv: []string{"lvchange", "--addtag", "a=1", "--deltag", "b=2", "vg0/pvc-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var argv []string
+			c := NewClient(&testingexec.FakeExec{
+				CommandScript: []testingexec.FakeCommandAction{
+					fakeCommand(&argv, `{"report":[{}]}`, "", nil),
+				},
+			}, 0, nil)
+
+			assert.NoError(t, test.run(c))
+			assert.Equal(t, test.expectedArgv, argv)
+		})
+	}
+}
+
+// contextExec records the context of each command it runs, along with the
+// error of the context when the command started
+type contextExec struct {
+	*testingexec.FakeExec
+	contexts []context.Context
+	errs     []error
+}
+
+func (e *contextExec) CommandContext(ctx context.Context, cmd string, args ...string) exec.Cmd {
+	e.contexts = append(e.contexts, ctx)
+	e.errs = append(e.errs, ctx.Err())
+	return e.FakeExec.CommandContext(ctx, cmd, args...)
+}
+
+func TestClientCancelledCall(t *testing.T) {
+	var argv []string
+	fakeExec := &contextExec{FakeExec: &testingexec.FakeExec{
+		CommandScript: []testingexec.FakeCommandAction{
+			fakeCommand(&argv, `{"report":[{}]}`, "", nil),
+			fakeCommand(&argv, "", "", nil),
+		},
+	}}
+	c := NewClient(fakeExec, time.Minute, nil)
+
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	cancel()
+
+	_, err := c.ListLogicalVolumes(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.NoError(t, c.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "pvc-1", Size: 8388608}))
+	if !assert.Len(t, fakeExec.contexts, 2) {
+		return
+	}
+
+	// Reports are cancelled along with the call
+	assert.ErrorIs(t, fakeExec.errs[0], context.Canceled)
+
+	// Changes are only bounded by the timeout, keeping the values of the call
+	assert.NoError(t, fakeExec.errs[1])
+	change := fakeExec.contexts[1]
+	deadline, ok := change.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 10*time.Second)
+	assert.Equal(t, "value", change.Value(key{}))
+}
+
+func TestClientGetLogicalVolume(t *testing.T) {
+	var argv []string
+	observer := &recordingObserver{}
+	c := NewClient(&testingexec.FakeExec{
+		CommandScript: []testingexec.FakeCommandAction{
+			fakeCommand(&argv, lvsJSON, "", nil),
+			fakeCommand(&argv, "", `  Failed to find logical volume "vg0/pvc-2"`, testingexec.FakeExitError{Status: 5}),
+		},
+	}, 0, observer)
+
+	// lvsJSON holds two volumes where a single one is expected
+	_, err := c.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+
+	_, err = c.GetLogicalVolume(context.Background(), "vg0", "pvc-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	var cmdErr *CommandError
+	assert.ErrorAs(t, err, &cmdErr)
+	assert.Equal(t, 5, cmdErr.ExitCode)
+	assert.Equal(t, `Failed to find logical volume "vg0/pvc-2"`, cmdErr.Stderr)
+
+	assert.Equal(t, []string{"lvs", "lvs"}, observer.commands)
+	assert.Equal(t, []error{nil, err}, observer.errs)
+}
+
+// recordingObserver records the commands it is told about
+type recordingObserver struct {
+	commands []string
+	errs     []error
+}
+
+func (o *recordingObserver) ObserveCommand(command string, duration time.Duration, err error) {
+	o.commands = append(o.commands, command)
+	o.errs = append(o.errs, err)
+}
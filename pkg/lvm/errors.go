@@ -0,0 +1,87 @@
+package lvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when the requested volume group or logical volume does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when a logical volume with the same name exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrInsufficientSpace is returned when the volume group or thin pool cannot fit the request
+	ErrInsufficientSpace = errors.New("insufficient free space")
+	// ErrLocked is returned when another command holds the lock on the volume group
+	ErrLocked = errors.New("locked")
+)
+
+// stderrClasses maps the messages lvm prints on failure to the error they
+// stand for. Matching is case insensitive and the first match wins.
+var stderrClasses = []struct {
+	message string
+	err     error
+}{
+	{"insufficient free space", ErrInsufficientSpace},
+	{"insufficient suitable allocatable extents", ErrInsufficientSpace},
+	{"insufficient free extents", ErrInsufficientSpace},
+	{"already exists", ErrAlreadyExists},
+	{"failed to find", ErrNotFound},
+	{"not found", ErrNotFound},
+	{"giving up waiting for lock", ErrLocked},
+	{"can't get lock", ErrLocked},
+	{"failed to lock", ErrLocked},
+	{"resource temporarily unavailable", ErrLocked},
+	{"locked by other host", ErrLocked},
+}
+
+// CommandError is returned when an lvm command exits unsuccessfully
+type CommandError struct {
+	Command  string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s %s failed: %v: %s", e.Command, strings.Join(e.Args, " "), e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is lets callers match a failed command against the errors of this package
+func (e *CommandError) Is(target error) bool {
+	return target != nil && classify(e.Stderr) == target
+}
+
+// classify returns the error the stderr of an lvm command stands for, or nil
+// when it is not one the driver tells apart
+func classify(stderr string) error {
+	stderr = strings.ToLower(stderr)
+	for _, class := range stderrClasses {
+		if strings.Contains(stderr, class.message) {
+			return class.err
+		}
+	}
+
+	return nil
+}
+
+// sanitizeStderr drops the warnings and blank lines lvm mixes into its error
+// output and joins what is left on a single line
+func sanitizeStderr(stderr string) string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "WARNING:") || strings.Contains(line, "leaked on") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "; ")
+}
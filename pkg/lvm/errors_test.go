@@ -0,0 +1,95 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandErrorClassification(t *testing.T) {
+	// stderr captured from lvm2 2.03 on failing commands
+	tests := []struct {
+		desc        string
+		stderr      string
+		expectedErr error
+	}{
+		{
+			desc:        "lvcreate larger than the volume group",
+			stderr:      `  Volume group "vg0" has insufficient free space (255 extents): 256 required.`,
+			expectedErr: ErrInsufficientSpace,
+		},
+		{
+			desc:        "lvextend past the free space",
+			stderr:      `  Insufficient free space: 512 extents needed, but only 255 available`,
+			expectedErr: ErrInsufficientSpace,
+		},
+		{
+			desc:        "lvcreate on a full physical volume",
+			stderr:      `  Insufficient suitable allocatable extents for logical volume pvc-1: 100 more required`,
+			expectedErr: ErrInsufficientSpace,
+		},
+		{
+			desc:        "missing logical volume",
+			stderr:      `  Failed to find logical volume "vg0/pvc-1"`,
+			expectedErr: ErrNotFound,
+		},
+		{
+			desc:        "missing volume group",
+			stderr:      "  Volume group \"vg1\" not found\n  Cannot process volume group vg1",
+			expectedErr: ErrNotFound,
+		},
+		{
+			desc:        "duplicate logical volume",
+			stderr:      `  Logical Volume "pvc-1" already exists in volume group "vg0"`,
+			expectedErr: ErrAlreadyExists,
+		},
+		{
+			desc:        "lock timeout",
+			stderr:      "  Giving up waiting for lock.\n  Can't get lock for vg0",
+			expectedErr: ErrLocked,
+		},
+		{
+			desc:        "lvmlockd conflict",
+			stderr:      `  LV locked by other host: vg0/pvc-1`,
+			expectedErr: ErrLocked,
+		},
+		{
+			desc:        "busy lock file",
+			stderr:      `  /run/lock/lvm/V_vg0:aux: flock failed: Resource temporarily unavailable`,
+			expectedErr: ErrLocked,
+		},
+		{
+			desc:        "failed lock of a logical volume",
+			stderr:      `  Failed to lock logical volume vg0/pvc-1.`,
+			expectedErr: ErrLocked,
+		},
+		{
+			desc:        "volume in use",
+			stderr:      `  Logical volume vg0/pvc-1 contains a filesystem in use.`,
+			expectedErr: nil,
+		},
+	}
+
+	known := []error{ErrNotFound, ErrAlreadyExists, ErrInsufficientSpace, ErrLocked}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := &CommandError{Command: "lvcreate", ExitCode: 5, Stderr: sanitizeStderr(test.stderr), Err: errors.New("exit status 5")}
+
+			for _, target := range known {
+				assert.Equal(t, target == test.expectedErr, errors.Is(err, target), "matching %v", target)
+			}
+		})
+	}
+}
+
+func TestSanitizeStderr(t *testing.T) {
+	stderr := "  WARNING: Not using device /dev/sdb for PV abc.\n" +
+		"File descriptor 7 (/dev/pts/0) leaked on lvcreate invocation. Parent PID 1: bash\n" +
+		"\n" +
+		"  Volume group \"vg1\" not found\n" +
+		"  Cannot process volume group vg1\n"
+
+	assert.Equal(t, `Volume group "vg1" not found; Cannot process volume group vg1`, sanitizeStderr(stderr))
+}
@@ -0,0 +1,529 @@
+package lvm
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultExtentSize is the extent size lvm uses for new volume groups
+const DefaultExtentSize = 4 << 20
+
+// Fake is an in-memory implementation of Interface. It keeps track of volume
+// groups, their extents and logical volumes, and fails the way lvm does when
+// a volume group runs out of space or a volume is missing. Thin volumes take
+// no space from the volume group and never fill their pool, and snapshots only
+// fill when told to with SetDataPercent.
+type Fake struct {
+	mtx      sync.Mutex
+	vgs      map[string]*fakeVolumeGroup
+	failures map[string][]*CommandError
+	uuids    int
+}
+
+type fakeVolumeGroup struct {
+	vg  VolumeGroup
+	pv  PhysicalVolume
+	lvs map[string]*LogicalVolume
+}
+
+var _ Interface = &Fake{}
+
+func NewFake() *Fake {
+	return &Fake{
+		vgs:      map[string]*fakeVolumeGroup{},
+		failures: map[string][]*CommandError{},
+	}
+}
+
+// AddVolumeGroup creates a volume group of size bytes backed by a single physical volume
+func (f *Fake) AddVolumeGroup(name string, size uint64, extentSize uint64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	extents := size / extentSize
+	f.vgs[name] = &fakeVolumeGroup{
+		vg: VolumeGroup{
+			Name:        name,
+			UUID:        f.uuid(),
+			Attr:        "wz--n-",
+			Size:        extents * extentSize,
+			ExtentSize:  extentSize,
+			ExtentCount: extents,
+			PVCount:     1,
+		},
+		pv: PhysicalVolume{
+			Name: filepath.Join("/dev/fake", name),
+			UUID: f.uuid(),
+			VG:   name,
+			Attr: "a--",
+			Size: extents * extentSize,
+		},
+		lvs: map[string]*LogicalVolume{},
+	}
+}
+
+// AddThinPool creates a thin pool of size bytes in the volume group
+func (f *Fake) AddThinPool(vg string, name string, size uint64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, err := f.volumeGroup("lvcreate", vg)
+	if err != nil {
+		return err
+	}
+
+	return f.allocate(fvg, &LogicalVolume{Name: name, Attr: "twi-a-tz--", Size: size})
+}
+
+// FailNext makes the next run of command fail with stderr, as lvm would
+// report it. Failures queue up when called repeatedly for the same command.
+func (f *Fake) FailNext(command string, stderr string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.failures[command] = append(f.failures[command], &CommandError{
+		Command:  command,
+		ExitCode: 5,
+		Stderr:   sanitizeStderr(stderr),
+		Err:      fmt.Errorf("exit status 5"),
+	})
+}
+
+// SetDataPercent sets how full a thin pool or snapshot is. A copy-on-write
+// snapshot filled to 100% becomes invalid as it does with lvm.
+func (f *Fake) SetDataPercent(vg string, name string, percent float64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, ok := f.vgs[vg]
+	if !ok || fvg.lvs[name] == nil {
+		return fmt.Errorf("logical volume %s/%s does not exist", vg, name)
+	}
+
+	lv := fvg.lvs[name]
+	lv.DataPercent = percent
+	if lv.CopyOnWrite() && percent >= 100 {
+		lv.DataPercent = 100
+		attr := []byte(lv.Attr)
+		attr[4] = 'I'
+		lv.Attr = string(attr)
+	}
+
+	return nil
+}
+
+// SetMetadataPercent sets how full the metadata of a thin pool is
+func (f *Fake) SetMetadataPercent(vg string, name string, percent float64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, ok := f.vgs[vg]
+	if !ok || fvg.lvs[name] == nil {
+		return fmt.Errorf("logical volume %s/%s does not exist", vg, name)
+	}
+
+	fvg.lvs[name].MetadataPercent = percent
+	return nil
+}
+
+// SetAttr sets the attributes of a logical volume as lvs reports them, e.g.
+// to make it suspended or partial
+func (f *Fake) SetAttr(vg string, name string, attr string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, ok := f.vgs[vg]
+	if !ok || fvg.lvs[name] == nil {
+		return fmt.Errorf("logical volume %s/%s does not exist", vg, name)
+	}
+
+	fvg.lvs[name].Attr = attr
+	return nil
+}
+
+// SetPartial marks the physical volume of the volume group missing, making
+// the volume group partial, or found again
+func (f *Fake) SetPartial(vg string, partial bool) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, ok := f.vgs[vg]
+	if !ok {
+		return fmt.Errorf("volume group %s does not exist", vg)
+	}
+
+	vgAttr, pvAttr := []byte(fvg.vg.Attr), []byte(fvg.pv.Attr)
+	vgAttr[3], pvAttr[2] = '-', '-'
+	if partial {
+		vgAttr[3], pvAttr[2] = 'p', 'm'
+	}
+	fvg.vg.Attr, fvg.pv.Attr = string(vgAttr), string(pvAttr)
+
+	return nil
+}
+
+func (f *Fake) ListVolumeGroups(ctx context.Context) ([]*VolumeGroup, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err := f.injectedFailure("vgs"); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(f.vgs))
+	for name := range f.vgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vgs := make([]*VolumeGroup, 0, len(names))
+	for _, name := range names {
+		vgs = append(vgs, f.vgs[name].report())
+	}
+
+	return vgs, nil
+}
+
+func (f *Fake) GetVolumeGroup(ctx context.Context, name string) (*VolumeGroup, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, err := f.volumeGroup("vgs", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fvg.report(), nil
+}
+
+func (f *Fake) ListPhysicalVolumes(ctx context.Context, vg string) ([]*PhysicalVolume, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, err := f.volumeGroup("pvs", vg)
+	if err != nil {
+		return nil, err
+	}
+
+	pv := fvg.pv
+	pv.Free = fvg.report().Free
+
+	return []*PhysicalVolume{&pv}, nil
+}
+
+func (f *Fake) ListLogicalVolumes(ctx context.Context, vg string) ([]*LogicalVolume, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, err := f.volumeGroup("lvs", vg)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fvg.lvs))
+	for name := range fvg.lvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lvs := make([]*LogicalVolume, 0, len(names))
+	for _, name := range names {
+		lvs = append(lvs, copyLogicalVolume(fvg.lvs[name]))
+	}
+
+	return lvs, nil
+}
+
+func (f *Fake) GetLogicalVolume(ctx context.Context, vg string, name string) (*LogicalVolume, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	lv, err := f.logicalVolume("lvs", vg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyLogicalVolume(lv), nil
+}
+
+func (f *Fake) CreateLogicalVolume(ctx context.Context, opts CreateOptions) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fvg, err := f.volumeGroup("lvcreate", opts.VG)
+	if err != nil {
+		return err
+	}
+
+	if opts.Origin != "" {
+		return f.snapshot(fvg, opts)
+	}
+
+	attr := "-wi-a-----"
+	if opts.Pool != "" {
+		pool, ok := fvg.lvs[opts.Pool]
+		if !ok {
+			return commandError("lvcreate", 5, "Failed to find logical volume \"%s/%s\"", opts.VG, opts.Pool)
+		}
+		if !pool.ThinPool() {
+			return commandError("lvcreate", 5, "Logical volume %s/%s is not a thin pool.", opts.VG, opts.Pool)
+		}
+		attr = "Vwi-a-tz--"
+	}
+
+	return f.allocate(fvg, &LogicalVolume{
+		Name: opts.Name,
+		Attr: attr,
+		Size: opts.Size,
+		Pool: opts.Pool,
+		Tags: append([]string{}, opts.Tags...),
+	})
+}
+
+// snapshot takes a snapshot of the origin of opts. Thin snapshots share the
+// pool of their origin and are skipped on activation, as lvm does by default.
+// Copy-on-write snapshots take their size from the volume group.
+func (f *Fake) snapshot(fvg *fakeVolumeGroup, opts CreateOptions) error {
+	origin, ok := fvg.lvs[opts.Origin]
+	if !ok {
+		return commandError("lvcreate", 5, "Failed to find logical volume \"%s/%s\"", opts.VG, opts.Origin)
+	}
+
+	if opts.Size > 0 {
+		return f.allocate(fvg, &LogicalVolume{
+			Name:       opts.Name,
+			Attr:       "swi-a-s---",
+			Size:       opts.Size,
+			Origin:     origin.Name,
+			OriginSize: origin.Size,
+			Tags:       append([]string{}, opts.Tags...),
+		})
+	}
+
+	if origin.Pool == "" {
+		return commandError("lvcreate", 3, "Snapshot of a linear volume requires a size.")
+	}
+
+	return f.allocate(fvg, &LogicalVolume{
+		Name:       opts.Name,
+		Attr:       "Vwi---tz-k",
+		Size:       origin.Size,
+		Pool:       origin.Pool,
+		Origin:     origin.Name,
+		OriginSize: origin.Size,
+		Tags:       append([]string{}, opts.Tags...),
+	})
+}
+
+func (f *Fake) RemoveLogicalVolume(ctx context.Context, vg string, name string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, err := f.logicalVolume("lvremove", vg, name); err != nil {
+		return err
+	}
+
+	delete(f.vgs[vg].lvs, name)
+	return nil
+}
+
+func (f *Fake) ExtendLogicalVolume(ctx context.Context, vg string, name string, size uint64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	lv, err := f.logicalVolume("lvextend", vg, name)
+	if err != nil {
+		return err
+	}
+
+	fvg := f.vgs[vg]
+	current := lv.Size / fvg.vg.ExtentSize
+	requested := extents(size, fvg.vg.ExtentSize)
+	if requested <= current {
+		return commandError("lvextend", 5, "New size (%d extents) matches existing size (%d extents).", requested, current)
+	}
+
+	if free := fvg.report().FreeCount; lv.Pool == "" && requested-current > free {
+		return commandError("lvextend", 5, "Insufficient free space: %d extents needed, but only %d available", requested-current, free)
+	}
+
+	lv.Size = requested * fvg.vg.ExtentSize
+	return nil
+}
+
+func (f *Fake) SetLogicalVolumeActive(ctx context.Context, vg string, name string, active bool) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	lv, err := f.logicalVolume("lvchange", vg, name)
+	if err != nil {
+		return err
+	}
+
+	state := byte('-')
+	if active {
+		state = 'a'
+	}
+
+	attr := []byte(lv.Attr)
+	attr[4] = state
+	lv.Attr = string(attr)
+
+	return nil
+}
+
+func (f *Fake) UpdateLogicalVolumeTags(ctx context.Context, vg string, name string, add []string, remove []string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	lv, err := f.logicalVolume("lvchange", vg, name)
+	if err != nil {
+		return err
+	}
+
+	removed := map[string]bool{}
+	for _, tag := range remove {
+		removed[tag] = true
+	}
+
+	var tags []string
+	for _, tag := range lv.Tags {
+		if !removed[tag] {
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, tag := range add {
+		if !removed[tag] && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	lv.Tags = tags
+
+	return nil
+}
+
+// allocate adds the logical volume to the volume group, rounding its size up
+// to whole extents. Thin volumes take no extents from the volume group.
+func (f *Fake) allocate(fvg *fakeVolumeGroup, lv *LogicalVolume) error {
+	if _, ok := fvg.lvs[lv.Name]; ok {
+		return commandError("lvcreate", 5, "Logical Volume \"%s\" already exists in volume group \"%s\"", lv.Name, fvg.vg.Name)
+	}
+
+	required := extents(lv.Size, fvg.vg.ExtentSize)
+	if required == 0 {
+		return commandError("lvcreate", 3, "Size must be larger than 0.")
+	}
+
+	if lv.Pool == "" {
+		if free := fvg.report().FreeCount; required > free {
+			return commandError("lvcreate", 5, "Volume group \"%s\" has insufficient free space (%d extents): %d required.", fvg.vg.Name, free, required)
+		}
+	}
+
+	lv.Size = required * fvg.vg.ExtentSize
+	lv.VG = fvg.vg.Name
+	lv.UUID = f.uuid()
+	lv.Path = filepath.Join("/dev", fvg.vg.Name, lv.Name)
+	lv.CreationTime = time.Now().Truncate(time.Second)
+	fvg.lvs[lv.Name] = lv
+
+	return nil
+}
+
+// volumeGroup returns the named volume group, failing command the way lvm
+// does when it is missing
+func (f *Fake) volumeGroup(command string, name string) (*fakeVolumeGroup, error) {
+	if err := f.injectedFailure(command); err != nil {
+		return nil, err
+	}
+
+	fvg, ok := f.vgs[name]
+	if !ok {
+		return nil, commandError(command, 5, "Volume group \"%s\" not found", name)
+	}
+
+	return fvg, nil
+}
+
+// logicalVolume returns the named logical volume, failing command the way
+// lvm does when it is missing
+func (f *Fake) logicalVolume(command string, vg string, name string) (*LogicalVolume, error) {
+	fvg, err := f.volumeGroup(command, vg)
+	if err != nil {
+		return nil, err
+	}
+
+	lv, ok := fvg.lvs[name]
+	if !ok {
+		return nil, commandError(command, 5, "Failed to find logical volume \"%s/%s\"", vg, name)
+	}
+
+	return lv, nil
+}
+
+func (f *Fake) injectedFailure(command string) error {
+	failures := f.failures[command]
+	if len(failures) == 0 {
+		return nil
+	}
+
+	f.failures[command] = failures[1:]
+	return failures[0]
+}
+
+func (f *Fake) uuid() string {
+	f.uuids++
+	return fmt.Sprintf("fake-uuid-%d", f.uuids)
+}
+
+// report returns the volume group with its free space and counts updated
+func (fvg *fakeVolumeGroup) report() *VolumeGroup {
+	vg := fvg.vg
+	vg.LVCount = uint64(len(fvg.lvs))
+
+	var used uint64
+	for _, lv := range fvg.lvs {
+		if lv.Pool == "" {
+			used += lv.Size / vg.ExtentSize
+		}
+	}
+
+	vg.FreeCount = vg.ExtentCount - used
+	vg.Free = vg.FreeCount * vg.ExtentSize
+
+	return &vg
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func extents(size uint64, extentSize uint64) uint64 {
+	return (size + extentSize - 1) / extentSize
+}
+
+func copyLogicalVolume(lv *LogicalVolume) *LogicalVolume {
+	c := *lv
+	c.Tags = append([]string(nil), lv.Tags...)
+	return &c
+}
+
+func commandError(command string, exitCode int, format string, args ...interface{}) *CommandError {
+	return &CommandError{
+		Command:  command,
+		ExitCode: exitCode,
+		Stderr:   fmt.Sprintf(format, args...),
+		Err:      fmt.Errorf("exit status %d", exitCode),
+	}
+}
@@ -0,0 +1,262 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const mib = 1 << 20
+
+func newTestFake() *Fake {
+	f := NewFake()
+	f.AddVolumeGroup("vg0", 64*mib, DefaultExtentSize)
+	return f
+}
+
+func TestFakeCreateLogicalVolume(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	err := f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 5 * mib, Tags: []string{"a=1"}})
+	assert.NoError(t, err)
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "lv0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8*mib), lv.Size, "size should be rounded up to whole extents")
+	assert.Equal(t, "/dev/vg0/lv0", lv.Path)
+	assert.Equal(t, []string{"a=1"}, lv.Tags)
+	assert.True(t, lv.Active())
+
+	vg, err := f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(56*mib), vg.Free)
+	assert.Equal(t, uint64(14), vg.FreeCount)
+	assert.Equal(t, uint64(1), vg.LVCount)
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 4 * mib})
+	assert.ErrorContains(t, err, `Logical Volume "lv0" already exists in volume group "vg0"`)
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv1", Size: 60 * mib})
+	assert.ErrorContains(t, err, `Volume group "vg0" has insufficient free space (14 extents): 15 required.`)
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg1", Name: "lv1", Size: 4 * mib})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFakeThinPool(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.AddThinPool("vg0", "pool0", 32*mib))
+
+	lvs, err := f.ListLogicalVolumes(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Len(t, lvs, 1)
+	assert.True(t, lvs[0].ThinPool())
+
+	vg, err := f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(32*mib), vg.Free)
+
+	// Thin volumes may be larger than the volume group
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "thin0", Size: 128 * mib, Pool: "pool0"}))
+	assert.NoError(t, f.ExtendLogicalVolume(ctx, "vg0", "thin0", 256*mib))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "thin0")
+	assert.NoError(t, err)
+	assert.Equal(t, "pool0", lv.Pool)
+	assert.Equal(t, uint64(256*mib), lv.Size)
+
+	vg, err = f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(32*mib), vg.Free)
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "thin1", Size: 4 * mib, Pool: "thin0"})
+	assert.ErrorContains(t, err, "is not a thin pool")
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "thin1", Size: 4 * mib, Pool: "pool1"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFakeThinSnapshot(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.AddThinPool("vg0", "pool0", 32*mib))
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "thin0", Size: 128 * mib, Pool: "pool0"}))
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "snap0", Origin: "thin0", Tags: []string{"a=1"}}))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "snap0")
+	assert.NoError(t, err)
+	assert.True(t, lv.Snapshot())
+	assert.Equal(t, "thin0", lv.Origin)
+	assert.Equal(t, "pool0", lv.Pool)
+	assert.Equal(t, uint64(128*mib), lv.Size)
+	assert.Equal(t, []string{"a=1"}, lv.Tags)
+	assert.False(t, lv.Active())
+	assert.False(t, lv.CreationTime.IsZero())
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 4 * mib}))
+	assert.Error(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "snap1", Origin: "lv0"}))
+	assert.ErrorIs(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "snap1", Origin: "lv1"}), ErrNotFound)
+}
+
+func TestFakeCopyOnWriteSnapshot(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 32 * mib}))
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "snap0", Origin: "lv0", Size: 5 * mib}))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "snap0")
+	assert.NoError(t, err)
+	assert.True(t, lv.CopyOnWrite())
+	assert.Equal(t, "lv0", lv.Origin)
+	assert.Equal(t, uint64(8*mib), lv.Size)
+	assert.Equal(t, uint64(32*mib), lv.OriginSize)
+	assert.True(t, lv.Active())
+
+	vg, err := f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(24*mib), vg.Free, "the snapshot store should take space from the volume group")
+
+	assert.NoError(t, f.SetDataPercent("vg0", "snap0", 80))
+	lv, err = f.GetLogicalVolume(ctx, "vg0", "snap0")
+	assert.NoError(t, err)
+	assert.False(t, lv.Invalid())
+
+	assert.NoError(t, f.SetDataPercent("vg0", "snap0", 120))
+	lv, err = f.GetLogicalVolume(ctx, "vg0", "snap0")
+	assert.NoError(t, err)
+	assert.True(t, lv.Invalid())
+	assert.Equal(t, 100.0, lv.DataPercent)
+
+	err = f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "snap1", Origin: "lv0", Size: 32 * mib})
+	assert.ErrorIs(t, err, ErrInsufficientSpace)
+}
+
+func TestFakeSetPartial(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.SetPartial("vg0", true))
+	vg, err := f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.True(t, vg.Partial())
+
+	pvs, err := f.ListPhysicalVolumes(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.True(t, pvs[0].Missing())
+
+	assert.NoError(t, f.SetPartial("vg0", false))
+	vg, err = f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.False(t, vg.Partial())
+
+	assert.Error(t, f.SetPartial("vg1", true))
+}
+
+func TestFakeRemoveLogicalVolume(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 64 * mib}))
+	assert.NoError(t, f.RemoveLogicalVolume(ctx, "vg0", "lv0"))
+
+	_, err := f.GetLogicalVolume(ctx, "vg0", "lv0")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = f.RemoveLogicalVolume(ctx, "vg0", "lv0")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	vg, err := f.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, vg.Size, vg.Free)
+}
+
+func TestFakeExtendLogicalVolume(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib}))
+	assert.NoError(t, f.ExtendLogicalVolume(ctx, "vg0", "lv0", 13*mib))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "lv0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(16*mib), lv.Size)
+
+	assert.Error(t, f.ExtendLogicalVolume(ctx, "vg0", "lv0", 16*mib), "extending to the same size should fail")
+	assert.Error(t, f.ExtendLogicalVolume(ctx, "vg0", "lv0", 128*mib), "extending past the free space should fail")
+}
+
+func TestFakeSetLogicalVolumeActive(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib}))
+	assert.NoError(t, f.SetLogicalVolumeActive(ctx, "vg0", "lv0", false))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "lv0")
+	assert.NoError(t, err)
+	assert.False(t, lv.Active())
+	assert.Equal(t, "-wi-------", lv.Attr)
+}
+
+func TestFakeUpdateLogicalVolumeTags(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib, Tags: []string{"a=1", "b=2"}}))
+	assert.NoError(t, f.UpdateLogicalVolumeTags(ctx, "vg0", "lv0", []string{"c=3", "a=1"}, []string{"b=2"}))
+
+	lv, err := f.GetLogicalVolume(ctx, "vg0", "lv0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "c=3"}, lv.Tags)
+
+	assert.ErrorIs(t, f.UpdateLogicalVolumeTags(ctx, "vg0", "lv1", nil, nil), ErrNotFound)
+}
+
+func TestFakeFailNext(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+
+	f.FailNext("lvcreate", "  Volume group \"vg0\" has insufficient free space (0 extents): 2 required.")
+
+	err := f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib})
+	var cmdErr *CommandError
+	assert.True(t, errors.As(err, &cmdErr))
+	assert.Equal(t, 5, cmdErr.ExitCode)
+	assert.Contains(t, cmdErr.Stderr, "insufficient free space")
+
+	// Only the next run fails
+	assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib}))
+}
+
+func TestFakeListOrder(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFake()
+	f.AddVolumeGroup("a-vg", 8*mib, DefaultExtentSize)
+
+	for _, name := range []string{"lv2", "lv0", "lv1"} {
+		assert.NoError(t, f.CreateLogicalVolume(ctx, CreateOptions{VG: "vg0", Name: name, Size: 4 * mib}))
+	}
+
+	lvs, err := f.ListLogicalVolumes(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, "lv0", lvs[0].Name)
+	assert.Equal(t, "lv1", lvs[1].Name)
+	assert.Equal(t, "lv2", lvs[2].Name)
+
+	vgs, err := f.ListVolumeGroups(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "a-vg", vgs[0].Name)
+	assert.Equal(t, "vg0", vgs[1].Name)
+
+	pvs, err := f.ListPhysicalVolumes(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Len(t, pvs, 1)
+	assert.Equal(t, uint64(52*mib), pvs[0].Free)
+}
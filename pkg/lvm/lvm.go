@@ -0,0 +1,175 @@
+package lvm
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Interface is the set of lvm operations the driver relies on. It is
+// implemented by Client, which runs the lvm commands on the host, and by
+// Fake, which keeps everything in memory.
+type Interface interface {
+	// ListVolumeGroups returns every volume group on the host
+	ListVolumeGroups(ctx context.Context) ([]*VolumeGroup, error)
+	// GetVolumeGroup returns the volume group with the given name
+	GetVolumeGroup(ctx context.Context, name string) (*VolumeGroup, error)
+	// ListPhysicalVolumes returns the physical volumes backing the volume group
+	ListPhysicalVolumes(ctx context.Context, vg string) ([]*PhysicalVolume, error)
+	// ListLogicalVolumes returns every logical volume in the volume group
+	ListLogicalVolumes(ctx context.Context, vg string) ([]*LogicalVolume, error)
+	// GetLogicalVolume returns the logical volume with the given name in the volume group
+	GetLogicalVolume(ctx context.Context, vg string, name string) (*LogicalVolume, error)
+	// CreateLogicalVolume creates a logical volume as described by opts
+	CreateLogicalVolume(ctx context.Context, opts CreateOptions) error
+	// RemoveLogicalVolume removes the logical volume from the volume group
+	RemoveLogicalVolume(ctx context.Context, vg string, name string) error
+	// ExtendLogicalVolume grows the logical volume to size bytes
+	ExtendLogicalVolume(ctx context.Context, vg string, name string, size uint64) error
+	// SetLogicalVolumeActive activates or deactivates the logical volume
+	SetLogicalVolumeActive(ctx context.Context, vg string, name string, active bool) error
+	// UpdateLogicalVolumeTags adds and removes tags of the logical volume
+	UpdateLogicalVolumeTags(ctx context.Context, vg string, name string, add []string, remove []string) error
+}
+
+// VolumeGroup holds the attributes of an LVM volume group. Sizes are in bytes.
+type VolumeGroup struct {
+	Name        string
+	UUID        string
+	Attr        string
+	Size        uint64
+	Free        uint64
+	ExtentSize  uint64
+	ExtentCount uint64
+	FreeCount   uint64
+	PVCount     uint64
+	LVCount     uint64
+	Tags        []string
+}
+
+// Partial reports whether one or more physical volumes of the volume group are missing
+func (vg *VolumeGroup) Partial() bool {
+	return len(vg.Attr) > 3 && vg.Attr[3] == 'p'
+}
+
+// PhysicalVolume holds the attributes of an LVM physical volume. Sizes are in bytes.
+type PhysicalVolume struct {
+	Name string
+	UUID string
+	VG   string
+	Attr string
+	Size uint64
+	Free uint64
+}
+
+// Missing reports whether the device of the physical volume cannot be found
+func (pv *PhysicalVolume) Missing() bool {
+	return len(pv.Attr) > 2 && pv.Attr[2] == 'm'
+}
+
+// LogicalVolume holds the attributes of an LVM logical volume. Sizes are in bytes.
+type LogicalVolume struct {
+	Name string
+	UUID string
+	VG   string
+	Attr string
+	Size uint64
+	Path string
+	// Pool is the thin pool of a thin volume
+	Pool string
+	// Origin is the volume a snapshot was taken of
+	Origin string
+	// OriginSize is the size of the origin of a snapshot
+	OriginSize uint64
+	// DataPercent and MetadataPercent are the usage of a thin pool or snapshot
+	DataPercent     float64
+	MetadataPercent float64
+	// SyncPercent is how much of a RAID or mirrored volume is in sync
+	SyncPercent float64
+	// CreationTime is when the volume was created
+	CreationTime time.Time
+	Tags         []string
+}
+
+// Active reports whether the logical volume is activated and has a device node
+func (lv *LogicalVolume) Active() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'a'
+}
+
+// Snapshot reports whether the logical volume is a snapshot of another volume
+func (lv *LogicalVolume) Snapshot() bool {
+	return lv.Origin != ""
+}
+
+// CopyOnWrite reports whether the logical volume is a classic snapshot, keeping
+// the changed blocks of its origin in an exception store of its own size
+func (lv *LogicalVolume) CopyOnWrite() bool {
+	return len(lv.Attr) > 0 && lv.Attr[0] == 's'
+}
+
+// Invalid reports whether the logical volume is a snapshot that overflowed its
+// exception store and no longer holds the content of its origin
+func (lv *LogicalVolume) Invalid() bool {
+	return len(lv.Attr) > 4 && (lv.Attr[4] == 'I' || lv.Attr[4] == 'S')
+}
+
+// ThinPool reports whether the logical volume is a thin pool
+func (lv *LogicalVolume) ThinPool() bool {
+	return len(lv.Attr) > 0 && lv.Attr[0] == 't'
+}
+
+// Suspended reports whether the device of the logical volume is suspended,
+// holding back its I/O
+func (lv *LogicalVolume) Suspended() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 's'
+}
+
+// Partial reports whether one or more physical volumes the logical volume
+// is allocated on are missing
+func (lv *LogicalVolume) Partial() bool {
+	return len(lv.Attr) > 8 && lv.Attr[8] == 'p'
+}
+
+// Mirrored reports whether the logical volume is a RAID or mirrored volume
+func (lv *LogicalVolume) Mirrored() bool {
+	return len(lv.Attr) > 0 && strings.ContainsRune("rRmM", rune(lv.Attr[0]))
+}
+
+// OutOfSync reports whether the images of a RAID or mirrored volume differ:
+// they are still synchronizing, need a refresh or a scrub found mismatches
+func (lv *LogicalVolume) OutOfSync() bool {
+	if !lv.Mirrored() {
+		return false
+	}
+
+	return lv.SyncPercent < 100 || (len(lv.Attr) > 8 && (lv.Attr[8] == 'r' || lv.Attr[8] == 'm'))
+}
+
+// Exhausted reports whether a thin pool ran out of data or metadata space,
+// or failed, suspending the I/O of its volumes
+func (lv *LogicalVolume) Exhausted() bool {
+	if !lv.ThinPool() {
+		return false
+	}
+
+	return lv.DataPercent >= 100 || lv.MetadataPercent >= 100 || (len(lv.Attr) > 8 && strings.ContainsRune("DMF", rune(lv.Attr[8])))
+}
+
+// CreateOptions describes a logical volume to create
+type CreateOptions struct {
+	VG   string
+	Name string
+	// Size of the volume in bytes, the virtual size for thin volumes
+	Size uint64
+	// Pool is the thin pool to create a thin volume in, a linear volume is
+	// created when it is empty
+	Pool string
+	// Origin is the volume to take a snapshot of. The snapshot of a thin
+	// volume is a thin snapshot sharing its pool and is created without a size.
+	// Given a size, a copy-on-write snapshot with a store of that size is created.
+	Origin string
+	Tags   []string
+	// ExtraArgs are passed to lvcreate as is, e.g. to set a RAID level or
+	// stripes. The fake ignores them.
+	ExtraArgs []string
+}
@@ -0,0 +1,228 @@
+package lvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayout is the format of the times lvm reports
+const timeLayout = "2006-01-02 15:04:05 -0700"
+
+// Report columns requested from vgs, pvs and lvs
+const (
+	vgColumns = "vg_name,vg_uuid,vg_attr,vg_size,vg_free,vg_extent_size,vg_extent_count,vg_free_count,pv_count,lv_count,vg_tags"
+	pvColumns = "pv_name,pv_uuid,vg_name,pv_attr,pv_size,pv_free"
+	lvColumns = "lv_name,lv_uuid,vg_name,lv_attr,lv_size,lv_path,pool_lv,origin,origin_size,data_percent,metadata_percent,copy_percent,lv_time,lv_tags"
+)
+
+// report is the document printed by the lvm reporting commands with
+// --reportformat json. Every value is reported as a string.
+type report struct {
+	Report []struct {
+		VG []vgReport `json:"vg"`
+		PV []pvReport `json:"pv"`
+		LV []lvReport `json:"lv"`
+	} `json:"report"`
+}
+
+type vgReport struct {
+	Name        string `json:"vg_name"`
+	UUID        string `json:"vg_uuid"`
+	Attr        string `json:"vg_attr"`
+	Size        string `json:"vg_size"`
+	Free        string `json:"vg_free"`
+	ExtentSize  string `json:"vg_extent_size"`
+	ExtentCount string `json:"vg_extent_count"`
+	FreeCount   string `json:"vg_free_count"`
+	PVCount     string `json:"pv_count"`
+	LVCount     string `json:"lv_count"`
+	Tags        string `json:"vg_tags"`
+}
+
+type pvReport struct {
+	Name string `json:"pv_name"`
+	UUID string `json:"pv_uuid"`
+	VG   string `json:"vg_name"`
+	Attr string `json:"pv_attr"`
+	Size string `json:"pv_size"`
+	Free string `json:"pv_free"`
+}
+
+type lvReport struct {
+	Name            string `json:"lv_name"`
+	UUID            string `json:"lv_uuid"`
+	VG              string `json:"vg_name"`
+	Attr            string `json:"lv_attr"`
+	Size            string `json:"lv_size"`
+	Path            string `json:"lv_path"`
+	Pool            string `json:"pool_lv"`
+	Origin          string `json:"origin"`
+	OriginSize      string `json:"origin_size"`
+	DataPercent     string `json:"data_percent"`
+	MetadataPercent string `json:"metadata_percent"`
+	SyncPercent     string `json:"copy_percent"`
+	Time            string `json:"lv_time"`
+	Tags            string `json:"lv_tags"`
+}
+
+func decodeReport(out []byte) (*report, error) {
+	var r report
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode lvm report: %v", err)
+	}
+
+	return &r, nil
+}
+
+func parseVolumeGroups(out []byte) ([]*VolumeGroup, error) {
+	r, err := decodeReport(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var vgs []*VolumeGroup
+	for _, section := range r.Report {
+		for _, raw := range section.VG {
+			var p parser
+			vg := &VolumeGroup{
+				Name:        raw.Name,
+				UUID:        raw.UUID,
+				Attr:        raw.Attr,
+				Size:        p.uint(raw.Size),
+				Free:        p.uint(raw.Free),
+				ExtentSize:  p.uint(raw.ExtentSize),
+				ExtentCount: p.uint(raw.ExtentCount),
+				FreeCount:   p.uint(raw.FreeCount),
+				PVCount:     p.uint(raw.PVCount),
+				LVCount:     p.uint(raw.LVCount),
+				Tags:        parseTags(raw.Tags),
+			}
+			if p.err != nil {
+				return nil, fmt.Errorf("invalid report for volume group %s: %v", raw.Name, p.err)
+			}
+			vgs = append(vgs, vg)
+		}
+	}
+
+	return vgs, nil
+}
+
+func parsePhysicalVolumes(out []byte) ([]*PhysicalVolume, error) {
+	r, err := decodeReport(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var pvs []*PhysicalVolume
+	for _, section := range r.Report {
+		for _, raw := range section.PV {
+			var p parser
+			pv := &PhysicalVolume{
+				Name: raw.Name,
+				UUID: raw.UUID,
+				VG:   raw.VG,
+				Attr: raw.Attr,
+				Size: p.uint(raw.Size),
+				Free: p.uint(raw.Free),
+			}
+			if p.err != nil {
+				return nil, fmt.Errorf("invalid report for physical volume %s: %v", raw.Name, p.err)
+			}
+			pvs = append(pvs, pv)
+		}
+	}
+
+	return pvs, nil
+}
+
+func parseLogicalVolumes(out []byte) ([]*LogicalVolume, error) {
+	r, err := decodeReport(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var lvs []*LogicalVolume
+	for _, section := range r.Report {
+		for _, raw := range section.LV {
+			var p parser
+			lv := &LogicalVolume{
+				Name:            raw.Name,
+				UUID:            raw.UUID,
+				VG:              raw.VG,
+				Attr:            raw.Attr,
+				Size:            p.uint(raw.Size),
+				Path:            raw.Path,
+				Pool:            raw.Pool,
+				Origin:          raw.Origin,
+				OriginSize:      p.uint(raw.OriginSize),
+				DataPercent:     p.float(raw.DataPercent),
+				MetadataPercent: p.float(raw.MetadataPercent),
+				SyncPercent:     p.float(raw.SyncPercent),
+				CreationTime:    p.time(raw.Time),
+				Tags:            parseTags(raw.Tags),
+			}
+			if p.err != nil {
+				return nil, fmt.Errorf("invalid report for logical volume %s/%s: %v", raw.VG, raw.Name, p.err)
+			}
+			lvs = append(lvs, lv)
+		}
+	}
+
+	return lvs, nil
+}
+
+// parseTags splits the comma separated tag list lvm reports
+func parseTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+
+	return strings.Split(tags, ",")
+}
+
+// parser converts report values, keeping the first error it runs into
+type parser struct {
+	err error
+}
+
+func (p *parser) uint(value string) uint64 {
+	if p.err != nil || value == "" {
+		return 0
+	}
+
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		p.err = fmt.Errorf("invalid number %q: %v", value, err)
+	}
+
+	return v
+}
+
+func (p *parser) float(value string) float64 {
+	if p.err != nil || value == "" {
+		return 0
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.err = fmt.Errorf("invalid number %q: %v", value, err)
+	}
+
+	return v
+}
+
+func (p *parser) time(value string) time.Time {
+	if p.err != nil || value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(timeLayout, value)
+	if err != nil {
+		p.err = fmt.Errorf("invalid time %q: %v", value, err)
+	}
+
+	return t
+}
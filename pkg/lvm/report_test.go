@@ -0,0 +1,232 @@
+package lvm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reports as printed by lvm 2.03 with --reportformat json --units b --nosuffix
+const (
+	vgsJSON = `  {
+      "report": [
+          {
+              "vg": [
+                  {"vg_name":"vg0", "vg_uuid":"Ym1Ttd-0Hq3-Yb8R-9u6G-kCHV-eT3q-8bYqVb", "vg_attr":"wz--n-", "vg_size":"10733223936", "vg_free":"9655287808", "vg_extent_size":"4194304", "vg_extent_count":"2559", "vg_free_count":"2302", "pv_count":"1", "lv_count":"2", "vg_tags":""},
+                  {"vg_name":"vg1", "vg_uuid":"ZsYDYN-wIpn-gvSD-3Fy8-0SPU-1eRQ-8Ly1xm", "vg_attr":"wz-pn-", "vg_size":"21466447872", "vg_free":"21466447872", "vg_extent_size":"4194304", "vg_extent_count":"5118", "vg_free_count":"5118", "pv_count":"2", "lv_count":"0", "vg_tags":"fast,nvme"}
+              ]
+          }
+      ]
+  }
+`
+	pvsJSON = `  {
+      "report": [
+          {
+              "pv": [
+                  {"pv_name":"/dev/nvme0n1", "pv_uuid":"b4GwCc-Uyrl-3DBp-mvLY-JzKv-Bo3i-rnM3mI", "vg_name":"vg1", "pv_attr":"a--", "pv_size":"10733223936", "pv_free":"10733223936"},
+                  {"pv_name":"[unknown]", "pv_uuid":"2NzbhW-kfkF-Hsfl-fLTH-Jm3K-sDAB-LbZBzj", "vg_name":"vg1", "pv_attr":"a-m", "pv_size":"10733223936", "pv_free":"10733223936"}
+              ]
+          }
+      ]
+  }
+`
+	lvsJSON = `  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"pvc-1", "lv_uuid":"Yq2R4b-Ry2f-Pzd4-T0Yh-pBsI-8QZl-Fn0Gxq", "vg_name":"vg0", "lv_attr":"-wi-a-----", "lv_size":"1073741824", "lv_path":"/dev/vg0/pvc-1", "pool_lv":"", "origin":"", "origin_size":"", "data_percent":"", "metadata_percent":"", "lv_time":"2023-08-01 09:30:12 +0200", "lv_tags":"lvm.redhat.com/name=pvc-1,lvm.redhat.com/capacity=1073741824:0"},
+                  {"lv_name":"scratch", "lv_uuid":"xc9cDl-IJ8c-TLo9-hN5l-ENBd-Qqz1-hLq0SN", "vg_name":"vg0", "lv_attr":"-wi-------", "lv_size":"4194304", "lv_path":"/dev/vg0/scratch", "pool_lv":"pool0", "origin":"pvc-1", "origin_size":"1073741824", "data_percent":"0.00", "metadata_percent":"", "lv_time":"2023-08-02 10:00:00 +0000", "lv_tags":""},
+                  {"lv_name":"pool0", "lv_uuid":"3fDl0c-9Mqa-l1Pv-xe2K-PoNd-6W1y-ZmDJ1c", "vg_name":"vg0", "lv_attr":"twi-aotz--", "lv_size":"536870912", "lv_path":"", "pool_lv":"", "origin":"", "origin_size":"", "data_percent":"12.50", "metadata_percent":"3.02", "lv_time":"2023-07-30 18:45:01 +0000", "lv_tags":""},
+                  {"lv_name":"snap-1", "lv_uuid":"k2Vd8s-Qn1c-Wm0T-aR7e-Ub3L-Xo5p-Jd4Hzs", "vg_name":"vg0", "lv_attr":"swi-a-s---", "lv_size":"104857600", "lv_path":"/dev/vg0/snap-1", "pool_lv":"", "origin":"pvc-1", "origin_size":"1073741824", "data_percent":"42.17", "metadata_percent":"", "lv_time":"2023-08-03 11:15:00 +0000", "lv_tags":""},
+                  {"lv_name":"snap-2", "lv_uuid":"Tz0e4F-Lm2q-Hn6S-cV1b-Pd8W-Ry3k-Gs7Nxa", "vg_name":"vg0", "lv_attr":"swi-I-s---", "lv_size":"104857600", "lv_path":"/dev/vg0/snap-2", "pool_lv":"", "origin":"pvc-1", "origin_size":"1073741824", "data_percent":"100.00", "metadata_percent":"", "lv_time":"2023-08-03 11:20:00 +0000", "lv_tags":""}
+              ]
+          }
+      ]
+  }
+`
+)
+
+func TestParseVolumeGroups(t *testing.T) {
+	vgs, err := parseVolumeGroups([]byte(vgsJSON))
+	assert.NoError(t, err)
+	assert.Len(t, vgs, 2)
+
+	assert.Equal(t, &VolumeGroup{
+		Name:        "vg0",
+		UUID:        "Ym1Ttd-0Hq3-Yb8R-9u6G-kCHV-eT3q-8bYqVb",
+		Attr:        "wz--n-",
+		Size:        10733223936,
+		Free:        9655287808,
+		ExtentSize:  4194304,
+		ExtentCount: 2559,
+		FreeCount:   2302,
+		PVCount:     1,
+		LVCount:     2,
+	}, vgs[0])
+	assert.False(t, vgs[0].Partial())
+
+	assert.Equal(t, []string{"fast", "nvme"}, vgs[1].Tags)
+	assert.True(t, vgs[1].Partial())
+}
+
+func TestParsePhysicalVolumes(t *testing.T) {
+	pvs, err := parsePhysicalVolumes([]byte(pvsJSON))
+	assert.NoError(t, err)
+	assert.Len(t, pvs, 2)
+
+	assert.Equal(t, "/dev/nvme0n1", pvs[0].Name)
+	assert.Equal(t, "vg1", pvs[0].VG)
+	assert.Equal(t, uint64(10733223936), pvs[0].Size)
+	assert.False(t, pvs[0].Missing())
+	assert.True(t, pvs[1].Missing())
+}
+
+func TestParseLogicalVolumes(t *testing.T) {
+	lvs, err := parseLogicalVolumes([]byte(lvsJSON))
+	assert.NoError(t, err)
+	assert.Len(t, lvs, 5)
+
+	assert.Equal(t, &LogicalVolume{
+		Name:         "pvc-1",
+		UUID:         "Yq2R4b-Ry2f-Pzd4-T0Yh-pBsI-8QZl-Fn0Gxq",
+		VG:           "vg0",
+		Attr:         "-wi-a-----",
+		Size:         1073741824,
+		Path:         "/dev/vg0/pvc-1",
+		CreationTime: time.Date(2023, 8, 1, 9, 30, 12, 0, time.FixedZone("", 2*60*60)),
+		Tags:         []string{"lvm.redhat.com/name=pvc-1", "lvm.redhat.com/capacity=1073741824:0"},
+	}, lvs[0])
+	assert.True(t, lvs[0].Active())
+
+	assert.Nil(t, lvs[1].Tags)
+	assert.Equal(t, "pool0", lvs[1].Pool)
+	assert.Equal(t, "pvc-1", lvs[1].Origin)
+	assert.False(t, lvs[1].Active())
+	assert.False(t, lvs[1].ThinPool())
+	assert.True(t, lvs[1].Snapshot())
+	assert.False(t, lvs[1].CopyOnWrite())
+
+	assert.True(t, lvs[2].ThinPool())
+	assert.Equal(t, 12.5, lvs[2].DataPercent)
+	assert.Equal(t, 3.02, lvs[2].MetadataPercent)
+
+	assert.True(t, lvs[3].CopyOnWrite())
+	assert.Equal(t, uint64(104857600), lvs[3].Size)
+	assert.Equal(t, uint64(1073741824), lvs[3].OriginSize)
+	assert.Equal(t, 42.17, lvs[3].DataPercent)
+	assert.False(t, lvs[3].Invalid())
+
+	assert.True(t, lvs[4].Invalid())
+	assert.False(t, lvs[4].Active())
+}
+
+func TestParseRaidVolume(t *testing.T) {
+	lvs, err := parseLogicalVolumes([]byte(`{"report":[{"lv":[{"lv_name":"pvc-3", "vg_name":"vg0", "lv_attr":"rwi-a-r---", "lv_size":"1073741824", "copy_percent":"37.50"}]}]}`))
+	assert.NoError(t, err)
+	assert.Len(t, lvs, 1)
+
+	assert.Equal(t, 37.5, lvs[0].SyncPercent)
+	assert.True(t, lvs[0].Mirrored())
+	assert.True(t, lvs[0].OutOfSync())
+}
+
+func TestLogicalVolumeConditions(t *testing.T) {
+	tests := []struct {
+		desc              string
+		lv                LogicalVolume
+		expectedSuspended bool
+		expectedPartial   bool
+		expectedOutOfSync bool
+		expectedExhausted bool
+	}{
+		{
+			desc: "healthy linear volume",
+			lv:   LogicalVolume{Attr: "-wi-a-----"},
+		},
+		{
+			desc:              "suspended",
+			lv:                LogicalVolume{Attr: "-wi-s-----"},
+			expectedSuspended: true,
+		},
+		{
+			desc:            "partial",
+			lv:              LogicalVolume{Attr: "-wi-a---p-"},
+			expectedPartial: true,
+		},
+		{
+			desc: "raid in sync",
+			lv:   LogicalVolume{Attr: "rwi-a-r---", SyncPercent: 100},
+		},
+		{
+			desc:              "raid needing a refresh",
+			lv:                LogicalVolume{Attr: "rwi-a-r-r-", SyncPercent: 100},
+			expectedOutOfSync: true,
+		},
+		{
+			desc:              "raid with mismatches",
+			lv:                LogicalVolume{Attr: "rwi-a-r-m-", SyncPercent: 100},
+			expectedOutOfSync: true,
+		},
+		{
+			desc: "thin pool with space",
+			lv:   LogicalVolume{Attr: "twi-aotz--", DataPercent: 99.9, MetadataPercent: 10},
+		},
+		{
+			desc:              "thin pool data full",
+			lv:                LogicalVolume{Attr: "twi-aotz--", DataPercent: 100},
+			expectedExhausted: true,
+		},
+		{
+			desc:              "thin pool metadata read only",
+			lv:                LogicalVolume{Attr: "twi-aotzM-", DataPercent: 40},
+			expectedExhausted: true,
+		},
+		{
+			desc: "thin volume full is not a pool",
+			lv:   LogicalVolume{Attr: "Vwi-a-tz--", DataPercent: 100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedSuspended, test.lv.Suspended())
+			assert.Equal(t, test.expectedPartial, test.lv.Partial())
+			assert.Equal(t, test.expectedOutOfSync, test.lv.OutOfSync())
+			assert.Equal(t, test.expectedExhausted, test.lv.Exhausted())
+		})
+	}
+}
+
+func TestParseInvalidReports(t *testing.T) {
+	tests := []struct {
+		desc   string
+		output string
+	}{
+		{
+			desc:   "not json",
+			output: "  vg0 1 lvm2 a-- 10.00g 10.00g",
+		},
+		{
+			desc:   "size with suffix",
+			output: `{"report":[{"lv":[{"lv_name":"pvc-1", "lv_size":"1.00g"}]}]}`,
+		},
+		{
+			desc:   "time without zone",
+			output: `{"report":[{"lv":[{"lv_name":"pvc-1", "lv_time":"2023-08-01 09:30:12"}]}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := parseLogicalVolumes([]byte(test.output))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseEmptyReport(t *testing.T) {
+	lvs, err := parseLogicalVolumes([]byte(`{"report":[{"lv":[]}]}`))
+	assert.NoError(t, err)
+	assert.Empty(t, lvs)
+}
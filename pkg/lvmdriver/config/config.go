@@ -0,0 +1,191 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultLvmCommandTimeout bounds lvm commands when the file sets no timeout
+	DefaultLvmCommandTimeout = 5 * time.Minute
+	// DefaultShutdownTimeout leaves RPCs in flight time to complete within
+	// the default termination grace period of 30s of the pod
+	DefaultShutdownTimeout = 25 * time.Second
+	// maxLogLevel is the most verbose klog level the driver logs at
+	maxLogLevel = 10
+)
+
+// Config is the driver configuration file
+type Config struct {
+	// DeviceClasses are the device classes of the node
+	DeviceClasses []*svc.DeviceClass `json:"deviceClasses,omitempty"`
+	// DefaultFsType is the filesystem created on volumes when neither their
+	// capability nor their device class names one
+	DefaultFsType string `json:"defaultFsType,omitempty"`
+	// MountOptions are added to every filesystem mount, before the mount
+	// options of the StorageClass
+	MountOptions []string `json:"mountOptions,omitempty"`
+	Timeouts     Timeouts `json:"timeouts,omitempty"`
+	// LogLevel is the klog verbosity, the -v flag of the command line when unset
+	LogLevel *int `json:"logLevel,omitempty"`
+	// MetricsAddress is the host:port metrics are served on
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+}
+
+// Timeouts bound the operations of the driver
+type Timeouts struct {
+	// LvmCommand bounds each lvm command run on the host
+	LvmCommand Duration `json:"lvmCommand,omitempty"`
+	// Shutdown is how long RPCs in flight are given to complete when the
+	// driver is told to stop, before they are cancelled
+	Shutdown Duration `json:"shutdown,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "2m"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"90s\": %v", err)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// Load reads and validates the configuration file at path
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %v", err)
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Parse decodes a configuration file, rejecting unknown fields, and
+// validates it with the defaults filled in
+func Parse(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Default returns the configuration used without a configuration file
+func Default() *Config {
+	config := &Config{}
+	config.setDefaults()
+	return config
+}
+
+func (c *Config) setDefaults() {
+	if c.DefaultFsType == "" {
+		c.DefaultFsType = svc.DefaultFsType
+	}
+
+	if c.Timeouts.LvmCommand.Duration == 0 {
+		c.Timeouts.LvmCommand.Duration = DefaultLvmCommandTimeout
+	}
+
+	if c.Timeouts.Shutdown.Duration == 0 {
+		c.Timeouts.Shutdown.Duration = DefaultShutdownTimeout
+	}
+}
+
+// Validate reports every invalid field of the configuration
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, err := c.DeviceClassSet(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if !svc.IsSupportedFsType(c.DefaultFsType) {
+		errs = append(errs, fmt.Errorf("defaultFsType: unsupported filesystem %q", c.DefaultFsType))
+	}
+
+	for i, option := range c.MountOptions {
+		if option == "" || strings.ContainsAny(option, ", ") {
+			errs = append(errs, fmt.Errorf("mountOptions[%d]: %q must be a single non-empty mount option", i, option))
+		}
+	}
+
+	if c.Timeouts.LvmCommand.Duration < 0 {
+		errs = append(errs, fmt.Errorf("timeouts.lvmCommand: must not be negative, got %v", c.Timeouts.LvmCommand))
+	}
+
+	if c.Timeouts.Shutdown.Duration < 0 {
+		errs = append(errs, fmt.Errorf("timeouts.shutdown: must not be negative, got %v", c.Timeouts.Shutdown))
+	}
+
+	if c.LogLevel != nil && (*c.LogLevel < 0 || *c.LogLevel > maxLogLevel) {
+		errs = append(errs, fmt.Errorf("logLevel: must be between 0 and %d, got %d", maxLogLevel, *c.LogLevel))
+	}
+
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			errs = append(errs, fmt.Errorf("metricsAddress: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DeviceClassSet returns the device classes of the configuration
+func (c *Config) DeviceClassSet() (*svc.DeviceClasses, error) {
+	classes, err := svc.NewDeviceClasses(c.DeviceClasses)
+	if err != nil {
+		return nil, fmt.Errorf("deviceClasses: %v", err)
+	}
+
+	return classes, nil
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		desc        string
+		data        string
+		expected    *Config
+		expectedErr []string
+	}{
+		{
+			desc: "empty file",
+			data: "",
+			expected: &Config{
+				DefaultFsType: "ext4",
+				Timeouts:      Timeouts{LvmCommand: Duration{DefaultLvmCommandTimeout}, Shutdown: Duration{DefaultShutdownTimeout}},
+			},
+		},
+		{
+			desc: "every field",
+			data: `
+deviceClasses:
+  - name: fast
+    volumeGroup: nvme
+    fsType: xfs
+    spareGap: 1073741824
+    default: true
+  - name: bulk
+    volumeGroup: hdd
+    thinPool: pool0
+    overprovisionRatio: 5
+    lvcreateArgs: ["--type", "raid1"]
+defaultFsType: xfs
+mountOptions: [noatime]
+timeouts:
+  lvmCommand: 90s
+  shutdown: 10s
+logLevel: 4
+metricsAddress: ":8080"
+`,
+			expected: &Config{
+				DeviceClasses: []*svc.DeviceClass{
+					{Name: "fast", VolumeGroup: "nvme", FsType: "xfs", SpareGap: 1 << 30, Default: true},
+					{Name: "bulk", VolumeGroup: "hdd", ThinPool: "pool0", OverprovisionRatio: 5, LvcreateArgs: []string{"--type", "raid1"}},
+				},
+				DefaultFsType:  "xfs",
+				MountOptions:   []string{"noatime"},
+				Timeouts:       Timeouts{LvmCommand: Duration{90 * time.Second}, Shutdown: Duration{10 * time.Second}},
+				LogLevel:       intPtr(4),
+				MetricsAddress: ":8080",
+			},
+		},
+		{
+			desc:        "unknown field",
+			data:        "deviceClass: fast\n",
+			expectedErr: []string{`unknown field "deviceClass"`},
+		},
+		{
+			desc:        "invalid duration",
+			data:        "timeouts:\n  lvmCommand: soon\n",
+			expectedErr: []string{"soon"},
+		},
+		{
+			desc: "every invalid field is reported",
+			data: `
+deviceClasses:
+  - name: fast
+defaultFsType: btrfs
+mountOptions: ["noatime,nodiratime"]
+timeouts:
+  lvmCommand: -1s
+  shutdown: -1s
+logLevel: 11
+metricsAddress: "8080"
+`,
+			expectedErr: []string{
+				"deviceClasses: device class fast has no volume group",
+				`defaultFsType: unsupported filesystem "btrfs"`,
+				"mountOptions[0]",
+				"timeouts.lvmCommand",
+				"timeouts.shutdown",
+				"logLevel",
+				"metricsAddress",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config, err := Parse([]byte(test.data))
+			if len(test.expectedErr) > 0 {
+				assert.Nil(t, config)
+				for _, expected := range test.expectedErr {
+					assert.ErrorContains(t, err, expected)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, config)
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	current := func() *Config {
+		config := Default()
+		config.DeviceClasses = []*svc.DeviceClass{
+			{Name: "fast", VolumeGroup: "nvme", Default: true},
+			{Name: "thin", VolumeGroup: "hdd", ThinPool: "pool0"},
+		}
+		config.MetricsAddress = ":8080"
+		return config
+	}
+
+	tests := []struct {
+		desc   string
+		update func(*Config)
+		// expected updates the current configuration into the one applied,
+		// which is the next one when unset
+		expected    func(*Config)
+		expectedErr []string
+	}{
+		{
+			desc: "unchanged",
+		},
+		{
+			desc: "device class added",
+			update: func(c *Config) {
+				c.DeviceClasses = append(c.DeviceClasses, &svc.DeviceClass{Name: "bulk", VolumeGroup: "hdd"})
+			},
+		},
+		{
+			desc: "device class defaults changed",
+			update: func(c *Config) {
+				c.DeviceClasses[0] = &svc.DeviceClass{Name: "fast", VolumeGroup: "nvme", FsType: "xfs", SpareGap: 1 << 30}
+				c.DeviceClasses[1] = &svc.DeviceClass{Name: "thin", VolumeGroup: "hdd", ThinPool: "pool0", Default: true}
+			},
+		},
+		{
+			desc: "mount defaults and log level changed",
+			update: func(c *Config) {
+				c.DefaultFsType = "xfs"
+				c.MountOptions = []string{"noatime"}
+				c.LogLevel = intPtr(5)
+			},
+		},
+		{
+			desc: "device class removed",
+			update: func(c *Config) {
+				c.DeviceClasses = c.DeviceClasses[:1]
+			},
+			expected:    func(c *Config) {},
+			expectedErr: []string{"device class thin cannot be removed"},
+		},
+		{
+			desc: "volume group changed",
+			update: func(c *Config) {
+				c.DeviceClasses[0] = &svc.DeviceClass{Name: "fast", VolumeGroup: "ssd", Default: true}
+				c.DeviceClasses[1].FsType = "xfs"
+			},
+			expected: func(c *Config) {
+				c.DeviceClasses[1].FsType = "xfs"
+			},
+			expectedErr: []string{"device class fast cannot move from volume group nvme to ssd"},
+		},
+		{
+			desc: "thin pool changed",
+			update: func(c *Config) {
+				c.DeviceClasses[1] = &svc.DeviceClass{Name: "thin", VolumeGroup: "hdd", ThinPool: "pool1"}
+			},
+			expected:    func(c *Config) {},
+			expectedErr: []string{"device class thin cannot change its thin pool"},
+		},
+		{
+			desc: "default moved from a class that cannot change",
+			update: func(c *Config) {
+				c.DeviceClasses[0] = &svc.DeviceClass{Name: "fast", VolumeGroup: "ssd"}
+				c.DeviceClasses = append(c.DeviceClasses, &svc.DeviceClass{Name: "bulk", VolumeGroup: "hdd", Default: true})
+			},
+			expected: func(c *Config) {},
+			expectedErr: []string{
+				"device class fast cannot move from volume group nvme to ssd",
+				"device classes fast and bulk are both marked as default, keeping the previous device classes",
+			},
+		},
+		{
+			desc: "timeout changed",
+			update: func(c *Config) {
+				c.Timeouts.LvmCommand = Duration{time.Minute}
+			},
+			expected:    func(c *Config) {},
+			expectedErr: []string{"timeouts"},
+		},
+		{
+			desc: "metrics address and mount defaults changed",
+			update: func(c *Config) {
+				c.MetricsAddress = ":9090"
+				c.DefaultFsType = "xfs"
+			},
+			expected: func(c *Config) {
+				c.DefaultFsType = "xfs"
+			},
+			expectedErr: []string{"metricsAddress"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			next := current()
+			if test.update != nil {
+				test.update(next)
+			}
+			assert.NoError(t, next.Validate())
+
+			expected := next
+			if test.expected != nil {
+				expected = current()
+				test.expected(expected)
+			}
+
+			updated, errs := current().Update(next)
+			assert.Equal(t, expected, updated)
+			if assert.Len(t, errs, len(test.expectedErr)) {
+				for i, expectedErr := range test.expectedErr {
+					assert.ErrorContains(t, errs[i], expectedErr)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateFirstDeviceClass(t *testing.T) {
+	next := Default()
+	next.DeviceClasses = []*svc.DeviceClass{{Name: "fast", VolumeGroup: "nvme"}}
+	next.DefaultFsType = "xfs"
+
+	updated, errs := Default().Update(next)
+	assert.Empty(t, updated.DeviceClasses)
+	assert.Equal(t, "xfs", updated.DefaultFsType)
+	if assert.Len(t, errs, 1) {
+		assert.ErrorContains(t, errs[0], "restart the driver to add the first device class")
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+
+	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+)
+
+// Update returns the configuration to apply in place of c when next is
+// loaded, along with the reason each change of next that cannot be applied
+// while the driver runs was left out. Classes may be added and their
+// defaults may change, but the volumes of a class must stay where they were
+// provisioned: a class moved or removed keeps its previous settings.
+func (c *Config) Update(next *Config) (*Config, []error) {
+	var errs []error
+	updated := *next
+
+	if len(c.DeviceClasses) == 0 && len(next.DeviceClasses) > 0 {
+		errs = append(errs, fmt.Errorf("deviceClasses: the controller service is only enabled at startup, restart the driver to add the first device class"))
+		updated.DeviceClasses = c.DeviceClasses
+	} else {
+		classes, classErrs := c.updateDeviceClasses(next.DeviceClasses)
+		errs = append(errs, classErrs...)
+		updated.DeviceClasses = classes
+		// Classes kept from c may clash with those of next, e.g. both be the default
+		if _, err := updated.DeviceClassSet(); err != nil {
+			errs = append(errs, fmt.Errorf("%v, keeping the previous device classes", err))
+			updated.DeviceClasses = c.DeviceClasses
+		}
+	}
+
+	if next.Timeouts != c.Timeouts {
+		errs = append(errs, fmt.Errorf("timeouts: changes take effect when the driver restarts"))
+		updated.Timeouts = c.Timeouts
+	}
+
+	if next.MetricsAddress != c.MetricsAddress {
+		errs = append(errs, fmt.Errorf("metricsAddress: changes take effect when the driver restarts"))
+		updated.MetricsAddress = c.MetricsAddress
+	}
+
+	return &updated, errs
+}
+
+// updateDeviceClasses returns the classes of next, with those of c that
+// cannot change in place of their update
+func (c *Config) updateDeviceClasses(next []*svc.DeviceClass) ([]*svc.DeviceClass, []error) {
+	var errs []error
+	classes := make([]*svc.DeviceClass, 0, len(next))
+
+	for _, candidate := range next {
+		class := c.deviceClass(candidate.Name)
+		switch {
+		case class == nil:
+		case candidate.VolumeGroup != class.VolumeGroup:
+			errs = append(errs, fmt.Errorf("deviceClasses: device class %s cannot move from volume group %s to %s, its volumes stay in %s",
+				class.Name, class.VolumeGroup, candidate.VolumeGroup, class.VolumeGroup))
+			candidate = class
+		case candidate.ThinPool != class.ThinPool:
+			errs = append(errs, fmt.Errorf("deviceClasses: device class %s cannot change its thin pool from %q to %q, its volumes stay in %q",
+				class.Name, class.ThinPool, candidate.ThinPool, class.ThinPool))
+			candidate = class
+		}
+		classes = append(classes, candidate)
+	}
+
+	for _, class := range c.DeviceClasses {
+		var found bool
+		for _, candidate := range next {
+			found = found || candidate.Name == class.Name
+		}
+
+		if !found {
+			errs = append(errs, fmt.Errorf("deviceClasses: device class %s cannot be removed while volumes may still use it", class.Name))
+			classes = append(classes, class)
+		}
+	}
+
+	return classes, errs
+}
+
+// deviceClass returns the device class named name, nil if there is none
+func (c *Config) deviceClass(name string) *svc.DeviceClass {
+	for _, class := range c.DeviceClasses {
+		if class.Name == name {
+			return class
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Watcher reloads the configuration file when its content changes, applying
+// the changes Config.Update allows. The file is polled rather than watched for
+// events since ConfigMap volumes are updated by swapping a symlink.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	current  *Config
+	// data is the content last read, so an unchanged file is not checked again
+	data  []byte
+	apply func(*Config)
+}
+
+// NewWatcher watches the file at path, which current was loaded from. apply
+// is called with every valid configuration, less the changes that cannot be
+// applied.
+func NewWatcher(path string, current *Config, interval time.Duration, apply func(*Config)) (*Watcher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		current:  current,
+		data:     data,
+		apply:    apply,
+	}, nil
+}
+
+// Run checks the file every interval until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		w.reload()
+	}
+}
+
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		klog.Errorf("failed to read configuration %s: %v", w.path, err)
+		return
+	}
+
+	if bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+
+	next, err := Parse(data)
+	if err != nil {
+		klog.Errorf("ignoring invalid configuration %s: %v", w.path, err)
+		return
+	}
+
+	updated, errs := w.current.Update(next)
+	for _, err := range errs {
+		klog.Errorf("ignoring a change of configuration %s that cannot be applied without a restart: %v", w.path, err)
+	}
+
+	klog.Infof("applying configuration %s", w.path)
+	w.apply(updated)
+	w.current = updated
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+
+	classes := "deviceClasses:\n  - name: fast\n    volumeGroup: nvme\n  - name: bulk\n    volumeGroup: hdd\n"
+	write(classes)
+	current, err := Load(path)
+	assert.NoError(t, err)
+
+	var applied []*Config
+	watcher, err := NewWatcher(path, current, 0, func(c *Config) { applied = append(applied, c) })
+	assert.NoError(t, err)
+
+	// Unchanged content is not applied again
+	watcher.reload()
+	assert.Empty(t, applied)
+
+	// Changing the defaults of a device class is applied
+	write(classes + "    fsType: xfs\n")
+	watcher.reload()
+	assert.Len(t, applied, 1)
+	assert.Equal(t, "xfs", applied[0].DeviceClasses[1].FsType)
+
+	// Invalid files are not applied
+	write("deviceClasses:\n  - name: fast\n")
+	watcher.reload()
+	assert.Len(t, applied, 1)
+
+	// Unsafe changes, like moving a device class, are left out of the others
+	write("deviceClasses:\n  - name: fast\n    volumeGroup: sata\n  - name: bulk\n    volumeGroup: hdd\n    fsType: ext4\n")
+	watcher.reload()
+	if assert.Len(t, applied, 2) {
+		assert.Equal(t, "nvme", applied[1].DeviceClasses[0].VolumeGroup)
+		assert.Equal(t, "ext4", applied[1].DeviceClasses[1].FsType)
+	}
+
+	// Device classes may be added
+	write(classes + "    fsType: xfs\n  - name: slow\n    volumeGroup: sata\n")
+	watcher.reload()
+	if assert.Len(t, applied, 3) {
+		assert.Len(t, applied[2].DeviceClasses, 3)
+	}
+
+	// Later changes are checked against the configuration last applied
+	write(classes + "    fsType: ext4\n  - name: slow\n    volumeGroup: sata\n")
+	watcher.reload()
+	if assert.Len(t, applied, 4) {
+		assert.Equal(t, "ext4", applied[3].DeviceClasses[1].FsType)
+	}
+}
@@ -17,14 +17,89 @@ limitations under the License.
 package lvmdriver
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/config"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/metrics"
 	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/tracing"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
+	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
 )
 
 type LvmDriverOptions struct {
 	NodeID     string
 	DriverName string
 	Endpoint   string
+	// VolumeGroup enables the controller service, provisioning volumes in
+	// this volume group on the local node as the only device class
+	VolumeGroup string
+	// ConfigFile is the YAML configuration file of the driver, reloaded when
+	// it changes. Its device classes take the place of VolumeGroup.
+	ConfigFile string
+	// FakeLVM runs the driver against an in-memory lvm backend instead of
+	// the host, faking the mounts and the copies of its volumes too, for
+	// development and testing without disks
+	FakeLVM bool
+	// CopyBandwidth limits the bytes per second copied into volumes cloned
+	// from thick volumes, 0 leaves it unbounded
+	CopyBandwidth int64
+	// HealthAddress is the host:port /healthz and /readyz are served on,
+	// empty to not serve them
+	HealthAddress string
+	// MetricsAddress is the host:port /metrics is served on, empty to not
+	// serve metrics unless the configuration file sets the address
+	MetricsAddress string
+	// Tracing configures the export of traces, disabled without an endpoint
+	Tracing tracing.Options
+}
+
+// fakeVolumeGroupSize is the size of each volume group created for FakeLVM
+const fakeVolumeGroupSize = 100 << 30
+
+// snapshotMonitorInterval is how often copy-on-write snapshots are checked for overflow
+const snapshotMonitorInterval = time.Minute
+
+// configWatchInterval is how often the configuration file is checked for changes
+const configWatchInterval = 10 * time.Second
+
+// statusCacheTTL is how long the results of the status checks are reused,
+// sparing lvm commands to the frequent probes of the sidecars and the kubelet
+const statusCacheTTL = 5 * time.Second
+
+// thinPoolCriticalPercent is how full the data or metadata of a thin pool is
+// when the driver reports itself unready
+const thinPoolCriticalPercent = 95
+
+// tracingShutdownTimeout is how long Run waits for the spans still buffered
+// to be exported on exit
+const tracingShutdownTimeout = 5 * time.Second
+
+// kubeletPodsDir is the directory the kubelet mounts the volumes of pods in
+const kubeletPodsDir = "/var/lib/kubelet/pods"
+
+// legacyDeviceClass is the name of the device class made of the volume group
+// given by LvmDriverOptions.VolumeGroup
+const legacyDeviceClass = "default"
+
+// httpServer is a server started and stopped along with the grpc server
+type httpServer interface {
+	// Start serves until Stop, returning nil once stopped
+	Start() error
+	Stop()
 }
 
 type LvmDriver struct {
@@ -34,41 +109,361 @@ type LvmDriver struct {
 	version       string
 	statusService *svc.StatusService
 	grpcServer    svc.GrpcServer
+	// httpServers serve the status checks and the metrics, if enabled
+	httpServers []httpServer
+	// snapshotMonitor runs along with the controller service
+	snapshotMonitor *svc.SnapshotMonitor
+	// configWatcher reloads the configuration file, if any
+	configWatcher *config.Watcher
+	// tracing exports the spans of the RPCs, if enabled
+	tracing       *tracing.Tracing
+	deviceClasses *svc.DeviceClasses
+	nodeService   *svc.NodeService
+	// logLevel is the -v flag given on the command line, restored when the
+	// configuration no longer sets the log level
+	logLevel string
+	// shutdownTimeout is how long Run waits for RPCs in flight once signaled
+	shutdownTimeout time.Duration
 }
 
-func NewLvmDriver(options *LvmDriverOptions) *LvmDriver {
+func NewLvmDriver(options *LvmDriverOptions) (*LvmDriver, error) {
 	klog.V(1).Infof("Driver: %v version :%v", options.DriverName, driverVersion)
 
+	cfg, err := loadConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceClasses, err := cfg.DeviceClassSet()
+	if err != nil {
+		return nil, err
+	}
+
+	metricsAddress, err := resolveMetricsAddress(options, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metrics are recorded even when not served, it is cheap
+	driverMetrics := metrics.New()
+
+	interceptors := []grpc.UnaryServerInterceptor{driverMetrics.UnaryInterceptor}
+	// The commands run on the host and the mounts are traced along with the RPCs
+	var hostMounter mount.Interface = mount.New("")
+	var hostExec exec.Interface = exec.New()
+	var copier utils.Copier = utils.NewBlockCopier(options.CopyBandwidth)
+	if options.FakeLVM {
+		// The volumes of the in-memory backend have no device to format,
+		// mount or copy, so neither touches the host
+		hostMounter = mount.NewFakeMounter(nil)
+		hostExec = fakeExec()
+		copier = utils.NopCopier{}
+	}
+	var driverTracing *tracing.Tracing
+	if options.Tracing.Endpoint != "" {
+		driverTracing, err = tracing.New(options.DriverName, driverVersion, options.NodeID, options.Tracing)
+		if err != nil {
+			return nil, err
+		}
+		// The span of an RPC includes the time recorded by its metrics
+		interceptors = append([]grpc.UnaryServerInterceptor{driverTracing.UnaryInterceptor}, interceptors...)
+		hostMounter = driverTracing.Mounter(hostMounter)
+		hostExec = driverTracing.Exec(hostExec)
+	}
+
 	// Service setups
-	statusSvc := svc.NewStatusService()
-	idSvc := svc.NewIdentityService(options.DriverName, driverVersion, statusSvc.Ready)
-	nodeSvc := svc.NewNodeService(options.DriverName, options.NodeID)
+	mounter := mount.NewSafeFormatAndMount(hostMounter, hostExec)
+	lvmClient := newLvmClient(options, cfg, deviceClasses, hostExec, driverMetrics)
+	driverMetrics.RegisterVolumeGroups(svc.VolumeGroupStats(options.DriverName, deviceClasses, lvmClient))
+	statusSvc := svc.NewStatusService(statusCacheTTL, statusChecks(options, deviceClasses, lvmClient, mounter)...)
+	nodeSvc := svc.NewNodeService(options.DriverName, options.NodeID, deviceClasses, mounter, lvmClient)
+
+	// LVM is node local so the controller runs next to the node service
+	var controllerSvc csi.ControllerServer
+	var pluginCapabilities []*csi.PluginCapability
+	var snapshotMonitor *svc.SnapshotMonitor
+	if len(deviceClasses.List()) > 0 {
+		snapshotMonitor = svc.NewSnapshotMonitor(options.DriverName, deviceClasses, lvmClient, snapshotMonitorInterval)
+		controllerSvc = svc.NewControllerService(options.DriverName, options.NodeID, deviceClasses, lvmClient, copier, nodeSvc)
+		pluginCapabilities = append(pluginCapabilities,
+			svc.ServiceCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+			svc.ServiceCapability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS),
+			svc.VolumeExpansionCapability(csi.PluginCapability_VolumeExpansion_ONLINE),
+		)
+	}
+
+	idSvc := svc.NewIdentityService(options.DriverName, driverVersion, statusSvc.Ready, pluginCapabilities...)
 	// The primary grpc server
 	grpcServer := svc.NewGrpcServer(svc.GrpcServerConfig{
-		Endpoint:   options.Endpoint,
-		IdServer:   idSvc,
-		NodeServer: nodeSvc,
+		Endpoint:         options.Endpoint,
+		IdServer:         idSvc,
+		NodeServer:       nodeSvc,
+		ControllerServer: controllerSvc,
+		Interceptors:     interceptors,
 	})
 
 	lvmd := &LvmDriver{
-		name:          options.DriverName,
-		version:       driverVersion,
-		nodeID:        options.NodeID,
-		endpoint:      options.Endpoint,
-		statusService: &statusSvc,
-		grpcServer:    grpcServer,
+		name:            options.DriverName,
+		version:         driverVersion,
+		nodeID:          options.NodeID,
+		endpoint:        options.Endpoint,
+		statusService:   statusSvc,
+		grpcServer:      grpcServer,
+		snapshotMonitor: snapshotMonitor,
+		tracing:         driverTracing,
+		deviceClasses:   deviceClasses,
+		nodeService:     nodeSvc,
+		shutdownTimeout: cfg.Timeouts.Shutdown.Duration,
+	}
+	if verbosity := flag.Lookup("v"); verbosity != nil {
+		lvmd.logLevel = verbosity.Value.String()
+	}
+	lvmd.applyConfig(cfg)
+
+	if options.HealthAddress != "" {
+		lvmd.httpServers = append(lvmd.httpServers, svc.NewHealthServer(options.HealthAddress, statusSvc))
 	}
 
-	return lvmd
+	if metricsAddress != "" {
+		lvmd.httpServers = append(lvmd.httpServers, metrics.NewServer(metricsAddress, driverMetrics))
+	}
+
+	if options.ConfigFile != "" {
+		lvmd.configWatcher, err = config.NewWatcher(options.ConfigFile, cfg, configWatchInterval, lvmd.reloadConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return lvmd, nil
 }
 
-func (driver *LvmDriver) Run() {
+// Run serves the driver until SIGTERM or SIGINT, then stops taking new RPCs
+// and waits for those in flight up to the shutdown timeout before cancelling
+// them. It returns the exit code of the process: 0 once every RPC completed.
+func (driver *LvmDriver) Run() int {
 	versionInfo, err := GetVersionYAML(driver.name)
 	if err != nil {
 		klog.Fatalf("%v", err)
 	}
 	klog.V(1).Infof("\nDRIVER INFORMATION:\n-------------------\n%s\n\nStreaming logs below:", versionInfo)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	if driver.snapshotMonitor != nil {
+		go driver.snapshotMonitor.Run(ctx)
+	}
+
+	if driver.configWatcher != nil {
+		go driver.configWatcher.Run(ctx)
+	}
+
+	if driver.tracing != nil {
+		defer driver.shutdownTracing()
+	}
+
+	// The http servers stop along with the grpc server
+	httpStopped := make(chan error, len(driver.httpServers))
+	for _, server := range driver.httpServers {
+		server := server
+		go func() {
+			httpStopped <- server.Start()
+		}()
+		defer server.Stop()
+	}
+
 	// Spin up the grpc server
-	driver.grpcServer.Start()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- driver.grpcServer.Start()
+	}()
+
+	var httpFailed bool
+	select {
+	case sig := <-signals:
+		klog.Infof("received %v, shutting down", sig)
+	case err := <-stopped:
+		if err == nil {
+			err = fmt.Errorf("stopped without a signal")
+		}
+		klog.Errorf("grpc server failed: %v", err)
+		return 1
+	case err := <-httpStopped:
+		// The probes of the kubelet would fail, restart rather than wait for them
+		klog.Errorf("http server failed, shutting down: %v", err)
+		httpFailed = true
+	}
+	cancel()
+
+	code := driver.shutdown(signals)
+	if err := <-stopped; err != nil {
+		klog.Errorf("grpc server failed: %v", err)
+		return 1
+	}
+
+	if httpFailed {
+		return 1
+	}
+	return code
+}
+
+// shutdown stops the grpc server gracefully, forcing it to stop when the
+// shutdown timeout expires or another signal is received
+func (driver *LvmDriver) shutdown(signals <-chan os.Signal) int {
+	drained := make(chan struct{})
+	go func() {
+		driver.grpcServer.Stop()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(driver.shutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		klog.Info("all requests completed, exiting")
+		return 0
+	case <-timer.C:
+		klog.Errorf("requests still running after %v, cancelling them", driver.shutdownTimeout)
+	case sig := <-signals:
+		klog.Errorf("received %v while shutting down, cancelling the requests still running", sig)
+	}
+
+	driver.grpcServer.ForceStop()
+	<-drained
+	return 1
+}
+
+// shutdownTracing exports the spans still buffered, giving up after the
+// tracing shutdown timeout
+func (driver *LvmDriver) shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+
+	if err := driver.tracing.Shutdown(ctx); err != nil {
+		klog.Errorf("failed to export the remaining spans: %v", err)
+	}
+}
+
+// resolveMetricsAddress returns the address metrics are served on, given by
+// either the options or the configuration file
+func resolveMetricsAddress(options *LvmDriverOptions, cfg *config.Config) (string, error) {
+	if options.MetricsAddress != "" && cfg.MetricsAddress != "" && options.MetricsAddress != cfg.MetricsAddress {
+		return "", fmt.Errorf("metrics address %s differs from %s of the configuration file, give only one of them", options.MetricsAddress, cfg.MetricsAddress)
+	}
+
+	if cfg.MetricsAddress != "" {
+		return cfg.MetricsAddress, nil
+	}
+	return options.MetricsAddress, nil
+}
+
+// loadConfig reads the configuration file, or else returns the default
+// configuration with the volume group of the options as the only device class
+func loadConfig(options *LvmDriverOptions) (*config.Config, error) {
+	if options.ConfigFile == "" {
+		cfg := config.Default()
+		if options.VolumeGroup != "" {
+			cfg.DeviceClasses = append(cfg.DeviceClasses, &svc.DeviceClass{Name: legacyDeviceClass, VolumeGroup: options.VolumeGroup, Default: true})
+		}
+		return cfg, nil
+	}
+
+	if options.VolumeGroup != "" {
+		return nil, fmt.Errorf("a volume group and a configuration file cannot both be given, list the device classes in the configuration file")
+	}
+
+	return config.Load(options.ConfigFile)
+}
+
+// applyConfig applies the settings of the configuration that can change
+// while the driver runs
+func (driver *LvmDriver) applyConfig(cfg *config.Config) {
+	driver.nodeService.SetMountDefaults(cfg.DefaultFsType, cfg.MountOptions)
+
+	// The klog flags are registered on the command line flags by main
+	logLevel := driver.logLevel
+	if cfg.LogLevel != nil {
+		logLevel = strconv.Itoa(*cfg.LogLevel)
+	}
+	if logLevel != "" {
+		if err := flag.Set("v", logLevel); err != nil {
+			klog.Errorf("failed to set the log level to %s: %v", logLevel, err)
+		}
+	}
+}
+
+// reloadConfig applies a configuration the watcher found safe to apply. The
+// device classes keep their volume groups, but classes may have been added.
+func (driver *LvmDriver) reloadConfig(cfg *config.Config) {
+	deviceClasses, err := cfg.DeviceClassSet()
+	if err != nil {
+		klog.Errorf("failed to reload the device classes: %v", err)
+		return
+	}
+
+	for _, class := range deviceClasses.List() {
+		if driver.deviceClasses.Get(class.Name) == nil {
+			// NodeGetInfo is only called by the kubelet when the driver registers
+			klog.Warningf("device class %s added, its topology segment is only set on the node once the plugin registers with the kubelet again", class.Name)
+		}
+	}
+	driver.deviceClasses.Replace(deviceClasses)
+	driver.applyConfig(cfg)
+}
+
+// statusChecks returns the checks of the dependencies of the driver, run by
+// the Probe RPC and the health server
+func statusChecks(options *LvmDriverOptions, deviceClasses *svc.DeviceClasses, lvmClient lvm.Interface, mounter *mount.SafeFormatAndMount) []svc.StatusCheck {
+	checks := []svc.StatusCheck{svc.EndpointCheck(options.Endpoint)}
+	// The in-memory backend runs no lvm commands and is used outside of a
+	// pod, without the directories of the host mounted
+	if !options.FakeLVM {
+		checks = append(checks,
+			svc.LvmToolsCheck(exec.New()),
+			svc.MountPointsCheck(mounter, "/dev", kubeletPodsDir),
+		)
+	}
+
+	return append(checks,
+		svc.VolumeGroupsCheck(deviceClasses, lvmClient),
+		svc.ThinPoolsCheck(deviceClasses, lvmClient, thinPoolCriticalPercent),
+	)
+}
+
+func newLvmClient(options *LvmDriverOptions, cfg *config.Config, deviceClasses *svc.DeviceClasses, hostExec exec.Interface, observer lvm.CommandObserver) lvm.Interface {
+	if !options.FakeLVM {
+		return lvm.NewClient(hostExec, cfg.Timeouts.LvmCommand.Duration, observer)
+	}
+
+	klog.Warning("using an in-memory lvm backend, volumes will not be backed by any storage")
+	fakeLvm := lvm.NewFake()
+	for _, vg := range deviceClasses.VolumeGroups() {
+		fakeLvm.AddVolumeGroup(vg, fakeVolumeGroupSize, lvm.DefaultExtentSize)
+	}
+	for _, class := range deviceClasses.List() {
+		if class.ThinPool != "" {
+			// Pools shared by several classes are only created once
+			_ = fakeLvm.AddThinPool(class.VolumeGroup, class.ThinPool, fakeVolumeGroupSize/2)
+		}
+	}
+
+	return fakeLvm
+}
+
+// fakeExec returns an exec.Interface running no command, each of them
+// succeeding without output. blkid finding no filesystem, the fake volumes
+// are formatted on each stage and never resized.
+func fakeExec() exec.Interface {
+	return &testingexec.FakeExec{
+		DisableScripts: true,
+		LookPathFunc: func(file string) (string, error) {
+			return file, nil
+		},
+	}
 }
@@ -0,0 +1,157 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvmdriver
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/config"
+	svc "github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+func TestFakeLVMVolumeLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "csi.sock")
+
+	driver, err := NewLvmDriver(&LvmDriverOptions{
+		NodeID:      "node_001",
+		DriverName:  "lvm.redhat.com",
+		Endpoint:    "unix://" + socket,
+		VolumeGroup: "vg0",
+		FakeLVM:     true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- driver.grpcServer.Start()
+	}()
+	select {
+	case <-driver.grpcServer.Ready():
+	case err := <-started:
+		t.Fatalf("server stopped before listening: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not listen within 10s")
+	}
+	defer func() {
+		driver.grpcServer.Stop()
+		assert.NoError(t, <-started)
+	}()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controller := csi.NewControllerClient(conn)
+	node := csi.NewNodeClient(conn)
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+
+	created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{volCap},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	volumeId := created.GetVolume().GetVolumeId()
+	assert.Equal(t, "vg0/pvc-1", volumeId)
+	assert.Equal(t, int64(1<<30), created.GetVolume().GetCapacityBytes())
+
+	_, err = node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeId,
+		StagingTargetPath: staging,
+		VolumeCapability:  volCap,
+		VolumeContext:     created.GetVolume().GetVolumeContext(),
+	})
+	assert.NoError(t, err)
+
+	_, err = node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeId,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  volCap,
+	})
+	assert.NoError(t, err)
+
+	_, err = node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeId, TargetPath: target})
+	assert.NoError(t, err)
+
+	_, err = node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeId, StagingTargetPath: staging})
+	assert.NoError(t, err)
+
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeId})
+	assert.NoError(t, err)
+
+	// The volume is gone from the in-memory backend
+	_, err = controller.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeId})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestApplyConfigLogLevel(t *testing.T) {
+	// As main does
+	klog.InitFlags(nil)
+	defer func() {
+		assert.NoError(t, flag.Set("v", "0"))
+	}()
+
+	deviceClasses, err := svc.NewDeviceClasses(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	driver := &LvmDriver{
+		nodeService: svc.NewNodeService("lvm.redhat.com", "node_001", deviceClasses, nil, nil),
+		logLevel:    "2",
+	}
+
+	level := 5
+	cfg := config.Default()
+	cfg.LogLevel = &level
+	driver.applyConfig(cfg)
+
+	assert.Equal(t, "5", flag.Lookup("v").Value.String())
+	assert.True(t, klog.V(5).Enabled())
+	assert.False(t, klog.V(6).Enabled())
+
+	// Removing the log level from the configuration restores the -v flag
+	driver.applyConfig(config.Default())
+	assert.Equal(t, "2", flag.Lookup("v").Value.String())
+	assert.False(t, klog.V(3).Enabled())
+}
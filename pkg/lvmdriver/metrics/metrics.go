@@ -0,0 +1,129 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the Prometheus metrics of the driver: its RPCs,
+// the lvm commands it runs and the usage of the volume groups of its device
+// classes.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// namespace prefixes the names of the metrics of the driver
+const namespace = "lvm_driver"
+
+// Metrics holds the metrics of the driver in a registry of its own
+type Metrics struct {
+	registry *prometheus.Registry
+
+	grpcRequests       *prometheus.CounterVec
+	grpcDuration       *prometheus.HistogramVec
+	lvmCommandDuration *prometheus.HistogramVec
+	lvmCommandFailures *prometheus.CounterVec
+}
+
+var _ lvm.CommandObserver = &Metrics{}
+
+// New returns the metrics of the driver along with those of the Go runtime
+// and the process
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Number of CSI RPCs handled, by method and gRPC status code.",
+		}, []string{"method", "code"}),
+		// CSI RPCs copying the content of volumes run for minutes
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to handle CSI RPCs, by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+		}, []string{"method"}),
+		lvmCommandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "lvm",
+			Name:      "command_duration_seconds",
+			Help:      "Time taken to run lvm commands, by command.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"command"}),
+		lvmCommandFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "lvm",
+			Name:      "command_failures_total",
+			Help:      "Number of lvm commands that failed, by command.",
+		}, []string{"command"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.grpcRequests,
+		m.grpcDuration,
+		m.lvmCommandDuration,
+		m.lvmCommandFailures,
+	)
+
+	return m
+}
+
+// RegisterVolumeGroups adds the usage of the volume groups reported by stats
+// to the metrics, collected when the metrics are scraped
+func (m *Metrics) RegisterVolumeGroups(stats VolumeGroupStatsFunc) {
+	m.registry.MustRegister(&volumeGroupCollector{stats: stats})
+}
+
+// Registry returns the registry holding the metrics
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler returns the handler serving the metrics in the Prometheus format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// UnaryInterceptor records the number, status codes and duration of the RPCs
+func (m *Metrics) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	m.grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	m.grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+	return resp, err
+}
+
+// ObserveCommand records the duration and failures of an lvm command
+func (m *Metrics) ObserveCommand(command string, duration time.Duration, err error) {
+	m.lvmCommandDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		m.lvmCommandFailures.WithLabelValues(command).Inc()
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryInterceptor(t *testing.T) {
+	m := New()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	tests := []struct {
+		desc string
+		err  error
+	}{
+		{
+			desc: "success",
+		},
+		{
+			desc: "failure",
+			err:  status.Error(codes.ResourceExhausted, "volume group vg0 is full"),
+		},
+		{
+			desc: "another failure",
+			err:  status.Error(codes.ResourceExhausted, "volume group vg0 is full"),
+		},
+	}
+
+	for _, test := range tests {
+		_, err := m.UnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, test.err
+		})
+		assert.Equal(t, test.err, err, test.desc)
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.grpcRequests.WithLabelValues(info.FullMethod, "OK")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.grpcRequests.WithLabelValues(info.FullMethod, "ResourceExhausted")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.grpcDuration))
+}
+
+func TestObserveCommand(t *testing.T) {
+	m := New()
+
+	m.ObserveCommand("lvcreate", time.Second, nil)
+	m.ObserveCommand("lvcreate", 2*time.Second, fmt.Errorf("exit status 5"))
+	m.ObserveCommand("lvs", 10*time.Millisecond, nil)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lvmCommandFailures.WithLabelValues("lvcreate")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.lvmCommandFailures))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.lvmCommandDuration))
+}
+
+func TestVolumeGroupCollector(t *testing.T) {
+	collector := &volumeGroupCollector{
+		stats: func(ctx context.Context) ([]VolumeGroupStats, error) {
+			// vg1 could not be reported, vg0 still is
+			return []VolumeGroupStats{
+				{
+					Name:      "vg0",
+					Size:      1 << 30,
+					Free:      1 << 29,
+					ThinPools: []ThinPoolStats{{Name: "pool0", DataPercent: 12.5, MetadataPercent: 2}},
+					Volumes:   3,
+					Snapshots: 1,
+				},
+			}, fmt.Errorf("volume group vg1: not found")
+		},
+	}
+
+	expected := `
+# HELP lvm_driver_thin_pool_data_percent Percentage of the data of the thin pool in use.
+# TYPE lvm_driver_thin_pool_data_percent gauge
+lvm_driver_thin_pool_data_percent{thin_pool="pool0",volume_group="vg0"} 12.5
+# HELP lvm_driver_thin_pool_metadata_percent Percentage of the metadata of the thin pool in use.
+# TYPE lvm_driver_thin_pool_metadata_percent gauge
+lvm_driver_thin_pool_metadata_percent{thin_pool="pool0",volume_group="vg0"} 2
+# HELP lvm_driver_volume_group_free_bytes Unallocated space of the volume group in bytes.
+# TYPE lvm_driver_volume_group_free_bytes gauge
+lvm_driver_volume_group_free_bytes{volume_group="vg0"} 5.36870912e+08
+# HELP lvm_driver_volume_group_logical_volumes Number of logical volumes the driver created in the volume group, by type: volume or snapshot.
+# TYPE lvm_driver_volume_group_logical_volumes gauge
+lvm_driver_volume_group_logical_volumes{type="snapshot",volume_group="vg0"} 1
+lvm_driver_volume_group_logical_volumes{type="volume",volume_group="vg0"} 3
+# HELP lvm_driver_volume_group_size_bytes Size of the volume group in bytes.
+# TYPE lvm_driver_volume_group_size_bytes gauge
+lvm_driver_volume_group_size_bytes{volume_group="vg0"} 1.073741824e+09
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.ObserveCommand("lvs", time.Millisecond, nil)
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `lvm_driver_lvm_command_duration_seconds_count{command="lvs"} 1`)
+	assert.Contains(t, recorder.Body.String(), "go_goroutines")
+}
+
+func TestServerStop(t *testing.T) {
+	server := NewServer("127.0.0.1:0", New())
+
+	started := make(chan error, 1)
+	go func() {
+		started <- server.Start()
+	}()
+
+	select {
+	case <-server.Ready():
+	case err := <-started:
+		t.Fatalf("server stopped before listening: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not listen within 10s")
+	}
+
+	server.Stop()
+	assert.NoError(t, <-started)
+}
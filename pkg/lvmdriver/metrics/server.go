@@ -0,0 +1,82 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// readTimeout bounds reading the requests of the scrapers
+const readTimeout = 10 * time.Second
+
+// Server serves the metrics on /metrics
+type Server struct {
+	address string
+	server  *http.Server
+	ready   chan struct{}
+}
+
+func NewServer(address string, metrics *Metrics) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	return &Server{
+		address: address,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: readTimeout,
+		},
+		ready: make(chan struct{}),
+	}
+}
+
+// Start serves the metrics, blocking until the server is stopped. It returns
+// nil once stopped, or the error that kept it from serving.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.address, err)
+	}
+
+	klog.Infof("Serving metrics on address: %s", listener.Addr())
+	close(s.ready)
+
+	// A server stopped before serving returns ErrServerClosed at once
+	if err := s.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve metrics: %v", err)
+	}
+
+	return nil
+}
+
+// Ready is closed once the server listens for connections
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Stop closes the server, scrapes in progress are dropped
+func (s *Server) Stop() {
+	if err := s.server.Close(); err != nil {
+		klog.Errorf("Failed to stop the metrics server: %v", err)
+	}
+}
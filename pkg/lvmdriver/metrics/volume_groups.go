@@ -0,0 +1,113 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// collectTimeout bounds the lvm commands run for a scrape
+const collectTimeout = 10 * time.Second
+
+// VolumeGroupStats is the usage of a volume group of the device classes
+type VolumeGroupStats struct {
+	Name string
+	// Size and Free are in bytes
+	Size uint64
+	Free uint64
+	// ThinPools are the thin pools of the device classes in the volume group
+	ThinPools []ThinPoolStats
+	// Volumes and Snapshots are the number of logical volumes the driver
+	// created in the volume group
+	Volumes   int
+	Snapshots int
+}
+
+// ThinPoolStats is the usage of a thin pool, in percent
+type ThinPoolStats struct {
+	Name            string
+	DataPercent     float64
+	MetadataPercent float64
+}
+
+// VolumeGroupStatsFunc returns the usage of the volume groups it could
+// report, along with an error for the others
+type VolumeGroupStatsFunc func(ctx context.Context) ([]VolumeGroupStats, error)
+
+var (
+	volumeGroupSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "volume_group", "size_bytes"),
+		"Size of the volume group in bytes.",
+		[]string{"volume_group"}, nil)
+	volumeGroupFreeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "volume_group", "free_bytes"),
+		"Unallocated space of the volume group in bytes.",
+		[]string{"volume_group"}, nil)
+	logicalVolumesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "volume_group", "logical_volumes"),
+		"Number of logical volumes the driver created in the volume group, by type: volume or snapshot.",
+		[]string{"volume_group", "type"}, nil)
+	thinPoolDataDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "thin_pool", "data_percent"),
+		"Percentage of the data of the thin pool in use.",
+		[]string{"volume_group", "thin_pool"}, nil)
+	thinPoolMetadataDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "thin_pool", "metadata_percent"),
+		"Percentage of the metadata of the thin pool in use.",
+		[]string{"volume_group", "thin_pool"}, nil)
+)
+
+// volumeGroupCollector reports the usage of the volume groups on scrape, so
+// the metrics follow the device classes as they are reloaded
+type volumeGroupCollector struct {
+	stats VolumeGroupStatsFunc
+}
+
+func (c *volumeGroupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- volumeGroupSizeDesc
+	ch <- volumeGroupFreeDesc
+	ch <- logicalVolumesDesc
+	ch <- thinPoolDataDesc
+	ch <- thinPoolMetadataDesc
+}
+
+func (c *volumeGroupCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	stats, err := c.stats(ctx)
+	if err != nil {
+		// The volume groups that could be reported still are
+		klog.Errorf("failed to collect the usage of volume groups: %v", err)
+	}
+
+	for _, vg := range stats {
+		ch <- prometheus.MustNewConstMetric(volumeGroupSizeDesc, prometheus.GaugeValue, float64(vg.Size), vg.Name)
+		ch <- prometheus.MustNewConstMetric(volumeGroupFreeDesc, prometheus.GaugeValue, float64(vg.Free), vg.Name)
+		ch <- prometheus.MustNewConstMetric(logicalVolumesDesc, prometheus.GaugeValue, float64(vg.Volumes), vg.Name, "volume")
+		ch <- prometheus.MustNewConstMetric(logicalVolumesDesc, prometheus.GaugeValue, float64(vg.Snapshots), vg.Name, "snapshot")
+
+		for _, pool := range vg.ThinPools {
+			ch <- prometheus.MustNewConstMetric(thinPoolDataDesc, prometheus.GaugeValue, pool.DataPercent, vg.Name, pool.Name)
+			ch <- prometheus.MustNewConstMetric(thinPoolMetadataDesc, prometheus.GaugeValue, pool.MetadataPercent, vg.Name, pool.Name)
+		}
+	}
+}
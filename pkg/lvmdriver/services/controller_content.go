@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// copySourceSuffix names the snapshot of a volume a clone is copied from
+const copySourceSuffix = "-copy-source"
+
+// populateJob is a copy in progress into a volume created from a source
+// that cannot be thin snapshotted
+type populateJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// contentSourceName returns the logical volume named by the content source
+// of a request, which must be in the volume group of the device class
+func (c *ControllerService) contentSourceName(class *DeviceClass, source *csi.VolumeContentSource) (string, error) {
+	kind, id := "volume", source.GetVolume().GetVolumeId()
+	if source.GetSnapshot() != nil {
+		kind, id = "snapshot", source.GetSnapshot().GetSnapshotId()
+	}
+
+	if id == "" {
+		return "", status.Errorf(codes.InvalidArgument, "source %s id is missing from the request", kind)
+	}
+
+	vg, name, err := utils.ParseVolumeID(id)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if vg != class.VolumeGroup {
+		return "", status.Errorf(codes.InvalidArgument, "source %s %s is not in volume group %s of device class %s on node %s", kind, id, class.VolumeGroup, class.Name, c.nodeId)
+	}
+
+	return name, nil
+}
+
+// lookupContentSource finds the source of a request among the logical volumes
+// of the volume group, making sure it is a snapshot or volume of the driver
+func (c *ControllerService) lookupContentSource(lvs []*lvm.LogicalVolume, source *csi.VolumeContentSource, name string) (*lvm.LogicalVolume, error) {
+	var lv *lvm.LogicalVolume
+	for _, candidate := range lvs {
+		if candidate.Name == name {
+			lv = candidate
+		}
+	}
+
+	if source.GetSnapshot() != nil {
+		id := source.GetSnapshot().GetSnapshotId()
+		if lv == nil {
+			return nil, status.Errorf(codes.NotFound, "source snapshot %s does not exist on node %s", id, c.nodeId)
+		}
+		tags := parseSnapshotTags(c.driverName, lv)
+		if tags == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "source %s is not a snapshot of %s", id, c.driverName)
+		}
+		if snapshotOverflowed(lv, tags) {
+			return nil, snapshotOverflowError(id, lv)
+		}
+		return lv, nil
+	}
+
+	id := source.GetVolume().GetVolumeId()
+	if lv == nil {
+		return nil, status.Errorf(codes.NotFound, "source volume %s does not exist on node %s", id, c.nodeId)
+	}
+
+	tags := parseVolumeTags(c.driverName, lv)
+	if tags == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "source volume %s is not managed by %s", id, c.driverName)
+	}
+	if tags.populating {
+		return nil, status.Errorf(codes.Aborted, "source volume %s is still being populated", id)
+	}
+
+	return lv, nil
+}
+
+// createVolumeFromSource provisions a volume with the content of a snapshot
+// or volume. A thin source in the thin pool of the request is thin
+// snapshotted; any other source is copied into a newly allocated volume in
+// the background, and Aborted is returned until the copy completes.
+func (c *ControllerService) createVolumeFromSource(ctx context.Context, req *csi.CreateVolumeRequest, class *DeviceClass, vg *lvm.VolumeGroup, lvs []*lvm.LogicalVolume, sourceName string, params *volumeParameters, tags *volumeTags) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+
+	source, err := c.lookupContentSource(lvs, req.GetVolumeContentSource(), sourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The volume is at least as large as its source
+	size := restoreSize(source)
+	if required := uint64(req.GetCapacityRange().GetRequiredBytes()); required > size {
+		size = (required + vg.ExtentSize - 1) / vg.ExtentSize * vg.ExtentSize
+	}
+	if limit := uint64(req.GetCapacityRange().GetLimitBytes()); limit > 0 && size > limit {
+		return nil, status.Errorf(codes.OutOfRange, "source %s/%s of %d bytes does not fit in the limit of %d bytes", vg.Name, source.Name, restoreSize(source), limit)
+	}
+
+	if err := c.checkCapacity(ctx, lvs, vg, class, params, size); err != nil {
+		return nil, err
+	}
+
+	if source.Pool != "" && source.Pool == params.thinPool {
+		return c.snapshotSource(ctx, req, class, source, tags, size)
+	}
+
+	tags.populating = true
+	lvmTags, err := tags.lvmTags(c.driverName)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	klog.FromContext(ctx).V(2).Info("creating volume to copy the source into", "name", name, "size", size, "source", utils.VolumeID(vg.Name, source.Name))
+	err = c.lvm.CreateLogicalVolume(ctx, lvm.CreateOptions{
+		VG:        vg.Name,
+		Name:      name,
+		Size:      size,
+		Pool:      params.thinPool,
+		Tags:      lvmTags,
+		ExtraArgs: class.LvcreateArgs,
+	})
+	if err != nil {
+		return nil, lvmError(err, "failed to create volume %s", name)
+	}
+
+	if err := c.startPopulating(ctx, name, source); err != nil {
+		return nil, err
+	}
+
+	return nil, status.Errorf(codes.Aborted, "volume %s is being populated from %s/%s", name, vg.Name, source.Name)
+}
+
+// snapshotSource creates the volume as a writable thin snapshot of a thin source
+func (c *ControllerService) snapshotSource(ctx context.Context, req *csi.CreateVolumeRequest, class *DeviceClass, source *lvm.LogicalVolume, tags *volumeTags, size uint64) (*csi.CreateVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+	name := req.GetName()
+
+	lvmTags, err := tags.lvmTags(c.driverName)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	logger.V(2).Info("creating volume as a thin snapshot of the source", "name", name, "source", utils.VolumeID(source.VG, source.Name))
+	err = c.lvm.CreateLogicalVolume(ctx, lvm.CreateOptions{
+		VG:     source.VG,
+		Name:   name,
+		Origin: source.Name,
+		Tags:   lvmTags,
+	})
+	if err != nil {
+		return nil, lvmError(err, "failed to create volume %s", name)
+	}
+
+	if size > source.Size {
+		logger.V(2).Info("extending volume", "name", name, "size", source.Size, "new_size", size)
+		if err := c.lvm.ExtendLogicalVolume(ctx, source.VG, name, size); err != nil {
+			// Retries would find a volume too small for the request, so start over
+			if removeErr := c.lvm.RemoveLogicalVolume(ctx, source.VG, name); removeErr != nil {
+				logger.Error(removeErr, "failed to remove volume after a failed extend", "name", name)
+			}
+			return nil, lvmError(err, "failed to extend volume %s", name)
+		}
+	}
+
+	return c.createVolumeResponse(class, name, size, req.GetVolumeContentSource()), nil
+}
+
+// resumePopulating is called for retries on a volume whose copy has not
+// completed, restarting the copy when it is not running, e.g. after a restart
+func (c *ControllerService) resumePopulating(ctx context.Context, class *DeviceClass, lvs []*lvm.LogicalVolume, req *csi.CreateVolumeRequest) error {
+	name := req.GetName()
+	if _, running := c.populating[utils.VolumeID(class.VolumeGroup, name)]; running {
+		return status.Errorf(codes.Aborted, "volume %s is still being populated", name)
+	}
+
+	sourceName, err := c.contentSourceName(class, req.GetVolumeContentSource())
+	if err != nil {
+		return err
+	}
+
+	source, err := c.lookupContentSource(lvs, req.GetVolumeContentSource(), sourceName)
+	if err != nil {
+		return err
+	}
+
+	klog.FromContext(ctx).V(2).Info("restarting the copy of the source into volume", "name", name, "source", utils.VolumeID(source.VG, source.Name))
+	if err := c.startPopulating(ctx, name, source); err != nil {
+		return err
+	}
+
+	return status.Errorf(codes.Aborted, "volume %s is being populated from %s/%s", name, source.VG, source.Name)
+}
+
+// startPopulating copies the source into the volume, in the same volume
+// group, in the background, then removes the populating tag of the volume.
+// Source volumes may be written to meanwhile, so they are copied from a
+// snapshot taken for the copy. The caller must hold c.mtx.
+func (c *ControllerService) startPopulating(ctx context.Context, name string, source *lvm.LogicalVolume) error {
+	// Snapshots are created with activation skipped, and either may have been deactivated
+	if !source.Active() {
+		if err := c.lvm.SetLogicalVolumeActive(ctx, source.VG, source.Name, true); err != nil {
+			return lvmError(err, "failed to activate source %s/%s", source.VG, source.Name)
+		}
+	}
+
+	copySource := source
+	if parseVolumeTags(c.driverName, source) != nil {
+		var err error
+		copySource, err = c.snapshotCopySource(ctx, name, source)
+		if err != nil {
+			return err
+		}
+	}
+
+	copyCtx, cancel := context.WithCancel(context.Background())
+	job := &populateJob{cancel: cancel, done: make(chan struct{})}
+	id := utils.VolumeID(source.VG, name)
+	c.populating[id] = job
+
+	src := utils.DevicePath(copySource.VG, copySource.Name)
+	dst := utils.DevicePath(source.VG, name)
+	// The copy outlives the call, its messages still carry the request
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), "name", name, "source", src)
+
+	go func() {
+		defer cancel()
+
+		err := c.copier.Copy(copyCtx, src, dst, int64(restoreSize(source)))
+		if copySource != source {
+			err = c.removeCopySource(logger, copySource, err)
+		}
+		close(job.done)
+
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		// The volume was deleted while it was being copied
+		if c.populating[id] != job {
+			return
+		}
+		delete(c.populating, id)
+
+		if err != nil {
+			logger.Error(err, "failed to populate volume")
+			return
+		}
+
+		if err := c.lvm.UpdateLogicalVolumeTags(context.Background(), source.VG, name, nil, []string{populatingTag(c.driverName)}); err != nil {
+			logger.Error(err, "failed to mark volume as populated")
+			return
+		}
+
+		logger.V(2).Info("volume is populated")
+	}()
+
+	return nil
+}
+
+// snapshotCopySource snapshots a source volume to copy into the volume name,
+// so the copy is not torn by writes to the volume. Thin volumes are thin
+// snapshotted, thick ones get a copy-on-write snapshot with the default
+// snapshot reserve. The snapshot of an earlier copy is replaced.
+func (c *ControllerService) snapshotCopySource(ctx context.Context, name string, source *lvm.LogicalVolume) (*lvm.LogicalVolume, error) {
+	snapshotName := name + copySourceSuffix
+	if err := c.lvm.RemoveLogicalVolume(ctx, source.VG, snapshotName); err != nil && !errors.Is(err, lvm.ErrNotFound) {
+		return nil, lvmError(err, "failed to remove snapshot %s/%s of an earlier copy", source.VG, snapshotName)
+	}
+
+	opts := lvm.CreateOptions{VG: source.VG, Name: snapshotName, Origin: source.Name}
+	if source.Pool == "" {
+		vg, err := c.lvm.GetVolumeGroup(ctx, source.VG)
+		if err != nil {
+			return nil, lvmError(err, "failed to get volume group %s", source.VG)
+		}
+		reserve, err := parseSnapshotReserve(defaultSnapshotReserve)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid default snapshot reserve: %v", err)
+		}
+		opts.Size = reserve.size(source.Size, vg.ExtentSize)
+	}
+
+	klog.FromContext(ctx).V(2).Info("snapshotting the source to copy", "name", name, "snapshot", utils.VolumeID(source.VG, snapshotName))
+	if err := c.lvm.CreateLogicalVolume(ctx, opts); err != nil {
+		return nil, lvmError(err, "failed to snapshot source %s/%s", source.VG, source.Name)
+	}
+
+	snapshot, err := c.lvm.GetLogicalVolume(ctx, source.VG, snapshotName)
+	if err != nil {
+		return nil, lvmError(err, "failed to look up snapshot %s/%s", source.VG, snapshotName)
+	}
+
+	// Thin snapshots are created with activation skipped
+	if !snapshot.Active() {
+		if err := c.lvm.SetLogicalVolumeActive(ctx, source.VG, snapshotName, true); err != nil {
+			return nil, lvmError(err, "failed to activate snapshot %s/%s", source.VG, snapshotName)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// removeCopySource removes the snapshot a volume was copied from, returning
+// the error of the copy. A copy-on-write snapshot that overflowed during the
+// copy no longer held the source, failing the copy.
+func (c *ControllerService) removeCopySource(logger klog.Logger, snapshot *lvm.LogicalVolume, copyErr error) error {
+	ctx := context.Background()
+	if copyErr == nil && snapshot.CopyOnWrite() {
+		lv, err := c.lvm.GetLogicalVolume(ctx, snapshot.VG, snapshot.Name)
+		switch {
+		case err != nil:
+			copyErr = fmt.Errorf("failed to look up snapshot %s/%s of the source: %v", snapshot.VG, snapshot.Name, err)
+		case lv.Invalid():
+			copyErr = fmt.Errorf("source changed by more than the reserve of %d bytes of snapshot %s/%s during the copy", lv.Size, snapshot.VG, snapshot.Name)
+		}
+	}
+
+	if err := c.lvm.RemoveLogicalVolume(ctx, snapshot.VG, snapshot.Name); err != nil && !errors.Is(err, lvm.ErrNotFound) {
+		logger.Error(err, "failed to remove the snapshot of the source", "snapshot", utils.VolumeID(snapshot.VG, snapshot.Name))
+	}
+
+	return copyErr
+}
+
+// stopPopulating cancels the copy into a volume, if any, and waits for it to
+// release the devices. The caller must hold c.mtx.
+func (c *ControllerService) stopPopulating(ctx context.Context, id string) {
+	job, ok := c.populating[id]
+	if !ok {
+		return
+	}
+
+	klog.FromContext(ctx).V(2).Info("cancelling the copy into volume", "id", id)
+	job.cancel()
+	<-job.done
+	delete(c.populating, id)
+}
@@ -0,0 +1,323 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCopier records copies, failing the first failures of them and
+// blocking each until block is closed when it is set
+type fakeCopier struct {
+	mtx      sync.Mutex
+	copies   []string
+	failures int
+	block    chan struct{}
+}
+
+func (f *fakeCopier) Copy(ctx context.Context, src string, dst string, size int64) error {
+	f.mtx.Lock()
+	f.copies = append(f.copies, fmt.Sprintf("%s %s %d", src, dst, size))
+	fail := len(f.copies) <= f.failures
+	f.mtx.Unlock()
+
+	if f.block != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.block:
+		}
+	}
+
+	if fail {
+		return errors.New("copy failed")
+	}
+	return nil
+}
+
+func (f *fakeCopier) copied() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string(nil), f.copies...)
+}
+
+func snapshotSource(id string) *csi.VolumeContentSource {
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+		},
+	}
+}
+
+func volumeSource(id string) *csi.VolumeContentSource {
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id},
+		},
+	}
+}
+
+func TestCreateVolumeFromThinSource(t *testing.T) {
+	thinParams := map[string]string{"thinPool": "pool0", "overprovisionRatio": "2"}
+
+	tests := []struct {
+		desc           string
+		source         *csi.VolumeContentSource
+		capRange       *csi.CapacityRange
+		params         map[string]string
+		expectedCode   codes.Code
+		expectedOrigin string
+		expectedSize   int64
+	}{
+		{
+			desc:           "restore a snapshot",
+			source:         snapshotSource("vg0/snap-1"),
+			params:         thinParams,
+			expectedOrigin: "snap-1",
+			expectedSize:   32 * mib,
+		},
+		{
+			desc:           "clone a volume",
+			source:         volumeSource("vg0/pvc-1"),
+			params:         thinParams,
+			expectedOrigin: "pvc-1",
+			expectedSize:   32 * mib,
+		},
+		{
+			desc:           "larger than the source",
+			source:         snapshotSource("vg0/snap-1"),
+			capRange:       &csi.CapacityRange{RequiredBytes: 47 * mib},
+			params:         thinParams,
+			expectedOrigin: "snap-1",
+			expectedSize:   48 * mib,
+		},
+		{
+			desc:           "smaller than the source",
+			source:         snapshotSource("vg0/snap-1"),
+			capRange:       &csi.CapacityRange{RequiredBytes: 8 * mib},
+			params:         thinParams,
+			expectedOrigin: "snap-1",
+			expectedSize:   32 * mib,
+		},
+		{
+			desc:         "limit below the source",
+			source:       snapshotSource("vg0/snap-1"),
+			capRange:     &csi.CapacityRange{RequiredBytes: 8 * mib, LimitBytes: 16 * mib},
+			params:       thinParams,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			desc:         "thin pool exhausted",
+			source:       snapshotSource("vg0/snap-1"),
+			params:       map[string]string{"thinPool": "pool0"},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:         "snapshot in another volume group",
+			source:       snapshotSource("vg1/snap-1"),
+			params:       thinParams,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "volume in another volume group",
+			source:       volumeSource("vg1/pvc-1"),
+			params:       thinParams,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing snapshot",
+			source:       snapshotSource("vg0/snap-2"),
+			params:       thinParams,
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "missing volume",
+			source:       volumeSource("vg0/pvc-9"),
+			params:       thinParams,
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "volume given as a snapshot",
+			source:       snapshotSource("vg0/pvc-1"),
+			params:       thinParams,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing snapshot id",
+			source:       snapshotSource(""),
+			params:       thinParams,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeThinVolume(t)
+			controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+			_, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"})
+			assert.NoError(t, err)
+
+			req := &csi.CreateVolumeRequest{
+				Name:                "pvc-3",
+				CapacityRange:       test.capRange,
+				VolumeCapabilities:  []*csi.VolumeCapability{mountCapability("ext4")},
+				Parameters:          test.params,
+				VolumeContentSource: test.source,
+			}
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				_, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3")
+				assert.ErrorIs(t, err, lvm.ErrNotFound, "no volume should be created")
+				return
+			}
+
+			assert.Equal(t, "vg0/pvc-3", resp.Volume.VolumeId)
+			assert.Equal(t, test.expectedSize, resp.Volume.CapacityBytes)
+			assert.Equal(t, test.source, resp.Volume.ContentSource)
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedOrigin, lv.Origin)
+			assert.Equal(t, uint64(test.expectedSize), lv.Size)
+
+			// Retries return the same volume
+			again, err := controllerSvc.CreateVolume(context.Background(), req)
+			assert.NoError(t, err)
+			assert.Equal(t, resp.Volume, again.Volume)
+
+			// The same name for another source conflicts
+			req.VolumeContentSource = volumeSource("vg0/pvc-1")
+			if test.source.GetVolume() != nil {
+				req.VolumeContentSource = snapshotSource("vg0/snap-1")
+			}
+			_, err = controllerSvc.CreateVolume(context.Background(), req)
+			assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		})
+	}
+}
+
+func TestCreateVolumeCopiesThickSource(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	copier := &fakeCopier{failures: 1}
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, copier, nil)
+	assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "pvc-2", false))
+
+	req := &csi.CreateVolumeRequest{
+		Name:                "pvc-3",
+		VolumeCapabilities:  []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: volumeSource("vg0/pvc-2"),
+	}
+
+	_, err := controllerSvc.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.Aborted, status.Code(err), "the volume should be populating")
+
+	lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3")
+	assert.NoError(t, err)
+	assert.Equal(t, "", lv.Origin)
+	assert.Equal(t, uint64(8*mib), lv.Size)
+	assert.Contains(t, lv.Tags, "SnapshotSvc/populating=true")
+
+	source, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-2")
+	assert.NoError(t, err)
+	assert.True(t, source.Active(), "the source should be activated to be read")
+
+	// The first copy fails so a retry has to copy again
+	var resp *csi.CreateVolumeResponse
+	assert.Eventually(t, func() bool {
+		resp, err = controllerSvc.CreateVolume(context.Background(), req)
+		return status.Code(err) == codes.OK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The source volume is copied from a snapshot, not written to meanwhile
+	assert.Equal(t, []string{
+		"/dev/vg0/pvc-3-copy-source /dev/vg0/pvc-3 8388608",
+		"/dev/vg0/pvc-3-copy-source /dev/vg0/pvc-3 8388608",
+	}, copier.copied())
+	assert.Equal(t, int64(8*mib), resp.Volume.CapacityBytes)
+	assert.Equal(t, req.VolumeContentSource, resp.Volume.ContentSource)
+
+	lv, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3")
+	assert.NoError(t, err)
+	assert.NotContains(t, lv.Tags, "SnapshotSvc/populating=true")
+
+	_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3-copy-source")
+	assert.ErrorIs(t, err, lvm.ErrNotFound, "the snapshot of the source should be removed")
+}
+
+func TestCreateVolumeCopySourceOverflow(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	copier := &fakeCopier{block: make(chan struct{})}
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, copier, nil)
+
+	req := &csi.CreateVolumeRequest{
+		Name:                "pvc-3",
+		VolumeCapabilities:  []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: volumeSource("vg0/pvc-2"),
+	}
+
+	_, err := controllerSvc.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	snapshot, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3-copy-source")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, snapshot.CopyOnWrite())
+	assert.Equal(t, "pvc-2", snapshot.Origin)
+
+	// The source is written to beyond the reserve of the snapshot during the copy
+	assert.NoError(t, fakeLvm.SetAttr("vg0", "pvc-3-copy-source", "swi-I-s---"))
+	close(copier.block)
+
+	// The copy is discarded and started over from a new snapshot
+	var resp *csi.CreateVolumeResponse
+	assert.Eventually(t, func() bool {
+		resp, err = controllerSvc.CreateVolume(context.Background(), req)
+		return status.Code(err) == codes.OK
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "vg0/pvc-3", resp.Volume.VolumeId)
+	assert.Len(t, copier.copied(), 2)
+
+	_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3-copy-source")
+	assert.ErrorIs(t, err, lvm.ErrNotFound)
+}
+
+func TestDeleteVolumeWhilePopulating(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	copier := &fakeCopier{block: make(chan struct{})}
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, copier, nil)
+
+	req := &csi.CreateVolumeRequest{
+		Name:                "pvc-3",
+		VolumeCapabilities:  []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: volumeSource("vg0/pvc-2"),
+	}
+
+	_, err := controllerSvc.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// Retries while the copy runs do not start another
+	_, err = controllerSvc.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = controllerSvc.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vg0/pvc-3"})
+	assert.NoError(t, err)
+
+	_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-3")
+	assert.ErrorIs(t, err, lvm.ErrNotFound)
+	assert.Len(t, copier.copied(), 1)
+}
@@ -0,0 +1,630 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/klog/v2"
+)
+
+// defaultVolumeSize is used when the request carries no capacity range
+const defaultVolumeSize = 1 << 30
+
+// lvNameRegexp matches the characters lvm allows in a logical volume name
+var lvNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]{0,126}$`)
+
+// ControllerService provisions logical volumes in the volume groups of the
+// device classes of the node it runs on
+type ControllerService struct {
+	csi.UnimplementedControllerServer
+	mtx           sync.Mutex // Serializes lvm calls that change the volume groups
+	capabilities  []csi.ControllerServiceCapability_RPC_Type
+	driverName    string
+	nodeId        string
+	deviceClasses *DeviceClasses
+	lvm           lvm.Interface
+	// copier populates volumes from sources that cannot be thin snapshotted
+	copier utils.Copier
+	// published tells the volumes the node service has mounted, nil when
+	// the controller runs without one
+	published PublishedVolumes
+	// populating holds the copies in progress by volume id, guarded by mtx
+	populating map[string]*populateJob
+}
+
+// PublishedVolumes tells whether a volume is staged or published on the node
+type PublishedVolumes interface {
+	Published(vg string, name string) (bool, error)
+}
+
+func NewControllerService(name string, nodeId string, deviceClasses *DeviceClasses, lvmClient lvm.Interface, copier utils.Copier, published PublishedVolumes) csi.ControllerServer {
+	return &ControllerService{
+		driverName:    name,
+		nodeId:        nodeId,
+		deviceClasses: deviceClasses,
+		lvm:           lvmClient,
+		copier:        copier,
+		published:     published,
+		populating:    map[string]*populateJob{},
+		capabilities: []csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+			csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		},
+	}
+}
+
+func (c *ControllerService) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	csiCapabilities := make([]*csi.ControllerServiceCapability, 0, len(c.capabilities))
+
+	for _, cap := range c.capabilities {
+		csiCapabilities = append(csiCapabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: cap,
+				},
+			},
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: csiCapabilities,
+	}, nil
+}
+
+func (c *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume name is missing from the request")
+	}
+
+	if !lvNameRegexp.MatchString(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "volume name %s is not a valid logical volume name", name)
+	}
+
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+
+	if !c.isAccessible(req.GetAccessibilityRequirements()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "node %s is not accessible from the requested topology", c.nodeId)
+	}
+
+	class, params, err := c.classParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceName string
+	if req.GetVolumeContentSource() != nil {
+		sourceName, err = c.contentSourceName(class, req.GetVolumeContentSource())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	vg, err := c.lvm.GetVolumeGroup(ctx, class.VolumeGroup)
+	if err != nil {
+		return nil, lvmError(err, "failed to get volume group %s", class.VolumeGroup)
+	}
+
+	size, err := volumeSize(req.GetCapacityRange(), vg.ExtentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := newVolumeTags(req, class)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	lvmTags, err := tags.lvmTags(c.driverName)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// The name tag identifies the volume created for a request so retries
+	// return the existing volume instead of creating another
+	lvs, err := c.lvm.ListLogicalVolumes(ctx, vg.Name)
+	if err != nil {
+		return nil, lvmError(err, "failed to list volumes in volume group %s", vg.Name)
+	}
+
+	for _, lv := range lvs {
+		existing := parseVolumeTags(c.driverName, lv)
+		if existing == nil {
+			if lv.Name == name {
+				return nil, status.Errorf(codes.AlreadyExists, "logical volume %s exists but is not managed by %s", name, c.driverName)
+			}
+			continue
+		}
+
+		if existing.name != name {
+			continue
+		}
+
+		if existing.params != tags.params || (existing.deviceClass != "" && existing.deviceClass != tags.deviceClass) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with different parameters", name)
+		}
+
+		if existing.content != tags.content {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with different content", name)
+		}
+
+		if !sizeInRange(lv.Size, req.GetCapacityRange()) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with size %d", name, lv.Size)
+		}
+
+		if existing.populating {
+			return nil, c.resumePopulating(ctx, class, lvs, req)
+		}
+
+		klog.FromContext(ctx).V(2).Info("volume already exists", "name", name, "id", utils.VolumeID(lv.VG, lv.Name))
+		return c.createVolumeResponse(class, lv.Name, lv.Size, req.GetVolumeContentSource()), nil
+	}
+
+	if sourceName != "" {
+		return c.createVolumeFromSource(ctx, req, class, vg, lvs, sourceName, params, tags)
+	}
+
+	if err := c.checkCapacity(ctx, lvs, vg, class, params, size); err != nil {
+		return nil, err
+	}
+
+	klog.FromContext(ctx).V(2).Info("creating volume", "name", name, "size", size, "vg", vg.Name, "device_class", class.Name)
+	err = c.lvm.CreateLogicalVolume(ctx, lvm.CreateOptions{
+		VG:        vg.Name,
+		Name:      name,
+		Size:      size,
+		Pool:      params.thinPool,
+		Tags:      lvmTags,
+		ExtraArgs: class.LvcreateArgs,
+	})
+	if err != nil {
+		return nil, lvmError(err, "failed to create volume %s", name)
+	}
+
+	return c.createVolumeResponse(class, name, size, nil), nil
+}
+
+func (c *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := c.checkVolumeGroup(req.GetVolumeId(), vg); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	lv, err := c.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			klog.FromContext(ctx).V(2).Info("volume is already removed")
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, lvmError(err, "failed to look up volume %s", req.GetVolumeId())
+	}
+
+	if parseVolumeTags(c.driverName, lv) == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not managed by %s", req.GetVolumeId(), c.driverName)
+	}
+
+	// lvm would remove the copy-on-write snapshots of the volume along with it
+	lvs, err := c.lvm.ListLogicalVolumes(ctx, vg)
+	if err != nil {
+		return nil, lvmError(err, "failed to list volumes in volume group %s", vg)
+	}
+	for _, snapshot := range lvs {
+		if snapshot.Origin == name && snapshot.CopyOnWrite() {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s has snapshot %s/%s, which must be deleted first", req.GetVolumeId(), vg, snapshot.Name)
+		}
+	}
+
+	c.stopPopulating(ctx, req.GetVolumeId())
+
+	klog.FromContext(ctx).V(2).Info("removing volume")
+	if err := c.lvm.RemoveLogicalVolume(ctx, vg, name); err != nil && !errors.Is(err, lvm.ErrNotFound) {
+		return nil, lvmError(err, "failed to remove volume %s", req.GetVolumeId())
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (c *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := c.lvm.GetLogicalVolume(ctx, vg, name); err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "volume %s does not exist", req.GetVolumeId())
+		}
+		return nil, lvmError(err, "failed to look up volume %s", req.GetVolumeId())
+	}
+
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: status.Convert(err).Message()}, nil
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// ControllerExpandVolume extends the logical volume to the requested size,
+// leaving the filesystem on it to be grown by NodeExpandVolume
+func (c *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "capacity range is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := c.checkVolumeGroup(req.GetVolumeId(), vg); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	volumeGroup, err := c.lvm.GetVolumeGroup(ctx, vg)
+	if err != nil {
+		return nil, lvmError(err, "failed to get volume group %s", vg)
+	}
+
+	size, err := volumeSize(req.GetCapacityRange(), volumeGroup.ExtentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	lv, err := c.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		return nil, lvmError(err, "failed to look up volume %s", req.GetVolumeId())
+	}
+
+	if lv.Pool != "" && lv.Size < size {
+		if err := c.checkThinPoolGrowth(ctx, lv, size-lv.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	// A volume already at or above the requested size is left alone so retries succeed
+	if lv.Size < size {
+		klog.FromContext(ctx).V(2).Info("extending volume", "size", lv.Size, "new_size", size)
+		if err := c.lvm.ExtendLogicalVolume(ctx, vg, name, size); err != nil {
+			return nil, lvmError(err, "failed to extend volume %s", req.GetVolumeId())
+		}
+	} else {
+		size = lv.Size
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         int64(size),
+		NodeExpansionRequired: req.GetVolumeCapability().GetBlock() == nil,
+	}, nil
+}
+
+// GetCapacity reports the space left in the volume group of the device class,
+// or in the thin pool named by the parameters, for the node the controller runs on
+func (c *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	// Nothing can be provisioned on other nodes or with capabilities the driver does not support
+	if req.GetAccessibleTopology() != nil && !c.matchesTopology(req.GetAccessibleTopology()) {
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	if len(req.GetVolumeCapabilities()) > 0 {
+		if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+			return &csi.GetCapacityResponse{}, nil
+		}
+	}
+
+	// Nothing can be provisioned from a device class that does not exist on this node
+	if c.deviceClasses.Get(req.GetParameters()[deviceClassParam]) == nil {
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	class, params, err := c.classParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	vg, err := c.lvm.GetVolumeGroup(ctx, class.VolumeGroup)
+	if err != nil {
+		return nil, lvmError(err, "failed to get volume group %s", class.VolumeGroup)
+	}
+
+	available := freeSpace(vg, class)
+	if params.thinPool != "" {
+		lvs, err := c.lvm.ListLogicalVolumes(ctx, vg.Name)
+		if err != nil {
+			return nil, lvmError(err, "failed to list volumes in volume group %s", vg.Name)
+		}
+
+		available, err = c.thinPoolCapacity(ctx, vg.Name, lvs, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: int64(available),
+		MaximumVolumeSize: wrapperspb.Int64(int64(available)),
+		MinimumVolumeSize: wrapperspb.Int64(int64(vg.ExtentSize)),
+	}, nil
+}
+
+// classParameters returns the device class selected by StorageClass
+// parameters along with the parameters, the defaults of the class filled in
+func (c *ControllerService) classParameters(params map[string]string) (*DeviceClass, *volumeParameters, error) {
+	name := params[deviceClassParam]
+	class := c.deviceClasses.Get(name)
+	if class == nil {
+		if name == "" {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "no device class is requested and node %s has no default device class", c.nodeId)
+		}
+		return nil, nil, status.Errorf(codes.InvalidArgument, "device class %s does not exist on node %s", name, c.nodeId)
+	}
+
+	volumeParams, err := parseVolumeParameters(class.parameters(params))
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return class, volumeParams, nil
+}
+
+// checkVolumeGroup makes sure the volume group of a volume or snapshot id
+// belongs to a device class of the node
+func (c *ControllerService) checkVolumeGroup(id string, vg string) error {
+	if !c.deviceClasses.HasVolumeGroup(vg) {
+		return status.Errorf(codes.InvalidArgument, "%s is not in a volume group of a device class on node %s", id, c.nodeId)
+	}
+
+	return nil
+}
+
+// volumeClass returns the device class a volume was created from, or the
+// class of its volume group when the tags name none that still uses it
+func (c *ControllerService) volumeClass(lv *lvm.LogicalVolume, tags *volumeTags) *DeviceClass {
+	if tags != nil {
+		if class := c.deviceClasses.Get(tags.deviceClass); tags.deviceClass != "" && class != nil && class.VolumeGroup == lv.VG {
+			return class
+		}
+	}
+
+	return c.deviceClasses.ForVolumeGroup(lv.VG)
+}
+
+// freeSpace returns the space of the volume group that thick volumes of the
+// device class may use, leaving its spare gap free
+func freeSpace(vg *lvm.VolumeGroup, class *DeviceClass) uint64 {
+	if vg.Free <= class.SpareGap {
+		return 0
+	}
+
+	return vg.Free - class.SpareGap
+}
+
+// checkCapacity makes sure a volume of size bytes fits in the thin pool of the
+// parameters, or in the free space of the volume group for thick volumes
+func (c *ControllerService) checkCapacity(ctx context.Context, lvs []*lvm.LogicalVolume, vg *lvm.VolumeGroup, class *DeviceClass, params *volumeParameters, size uint64) error {
+	if params.thinPool == "" {
+		if free := freeSpace(vg, class); size > free {
+			return status.Errorf(codes.ResourceExhausted, "volume group %s has %d bytes free beyond a spare gap of %d bytes, %d requested",
+				vg.Name, free, class.SpareGap, size)
+		}
+		return nil
+	}
+
+	available, err := c.thinPoolCapacity(ctx, vg.Name, lvs, params)
+	if err != nil {
+		return err
+	}
+
+	if size > available {
+		return status.Errorf(codes.ResourceExhausted, "thin pool %s has %d bytes left at an overprovision ratio of %g, %d requested",
+			params.thinPool, available, params.overprovisionRatio, size)
+	}
+
+	return nil
+}
+
+// thinPoolCapacity returns the virtual space left in a thin pool: its size
+// times the overprovision ratio, less the size of the thin volumes in it
+func (c *ControllerService) thinPoolCapacity(ctx context.Context, vg string, lvs []*lvm.LogicalVolume, params *volumeParameters) (uint64, error) {
+	var pool *lvm.LogicalVolume
+	var provisioned uint64
+	for _, lv := range lvs {
+		if lv.Name == params.thinPool && lv.ThinPool() {
+			pool = lv
+		}
+		if lv.Pool == params.thinPool {
+			provisioned += lv.Size
+		}
+	}
+
+	if pool == nil {
+		return 0, status.Errorf(codes.InvalidArgument, "thin pool %s does not exist in volume group %s", params.thinPool, vg)
+	}
+
+	klog.FromContext(ctx).V(4).Info("thin pool usage", "pool", utils.VolumeID(vg, pool.Name), "size", pool.Size,
+		"data_percent", pool.DataPercent, "metadata_percent", pool.MetadataPercent, "provisioned", provisioned)
+
+	virtual := uint64(float64(pool.Size) * params.overprovisionRatio)
+	if provisioned >= virtual {
+		return 0, nil
+	}
+
+	return virtual - provisioned, nil
+}
+
+// checkThinPoolGrowth makes sure growing a thin volume by growth bytes keeps
+// its pool within the overprovision ratio the volume was created with
+func (c *ControllerService) checkThinPoolGrowth(ctx context.Context, lv *lvm.LogicalVolume, growth uint64) error {
+	params := map[string]string{thinPoolParam: lv.Pool}
+	tags := parseVolumeTags(c.driverName, lv)
+	class := c.volumeClass(lv, tags)
+	if tags != nil {
+		created, err := tags.parameters()
+		if err != nil {
+			return status.Errorf(codes.Internal, "volume %s/%s has invalid tags: %v", lv.VG, lv.Name, err)
+		}
+		if ratio, ok := created[overprovisionRatioParam]; ok {
+			params[overprovisionRatioParam] = ratio
+		}
+	}
+
+	// Volumes created without a ratio use the default of their device class
+	if _, ok := params[overprovisionRatioParam]; !ok && class.OverprovisionRatio != 0 {
+		params[overprovisionRatioParam] = fmt.Sprint(class.OverprovisionRatio)
+	}
+
+	volumeParams, err := parseVolumeParameters(params)
+	if err != nil {
+		return status.Errorf(codes.Internal, "volume %s/%s has invalid parameters: %v", lv.VG, lv.Name, err)
+	}
+
+	lvs, err := c.lvm.ListLogicalVolumes(ctx, lv.VG)
+	if err != nil {
+		return lvmError(err, "failed to list volumes in volume group %s", lv.VG)
+	}
+
+	available, err := c.thinPoolCapacity(ctx, lv.VG, lvs, volumeParams)
+	if err != nil {
+		return err
+	}
+
+	if growth > available {
+		return status.Errorf(codes.ResourceExhausted, "thin pool %s has %d bytes left at an overprovision ratio of %g, %d more requested",
+			lv.Pool, available, volumeParams.overprovisionRatio, growth)
+	}
+
+	return nil
+}
+
+func (c *ControllerService) createVolumeResponse(class *DeviceClass, name string, size uint64, source *csi.VolumeContentSource) *csi.CreateVolumeResponse {
+	context := map[string]string{deviceClassContextKey: class.Name}
+	if class.FsType != "" {
+		context[fsTypeContextKey] = class.FsType
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           utils.VolumeID(class.VolumeGroup, name),
+			CapacityBytes:      int64(size),
+			VolumeContext:      context,
+			AccessibleTopology: []*csi.Topology{volumeTopology(c.driverName, c.nodeId, class)},
+			ContentSource:      source,
+		},
+	}
+}
+
+// isAccessible reports whether this node satisfies the requisite topology of the request
+func (c *ControllerService) isAccessible(req *csi.TopologyRequirement) bool {
+	if len(req.GetRequisite()) == 0 {
+		return true
+	}
+
+	for _, topology := range req.GetRequisite() {
+		if c.matchesTopology(topology) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesTopology reports whether every segment of the topology matches this node
+func (c *ControllerService) matchesTopology(topology *csi.Topology) bool {
+	segments := nodeTopology(c.driverName, c.nodeId, c.deviceClasses).Segments
+	for key, value := range topology.GetSegments() {
+		if segments[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// volumeSize returns the size in bytes to allocate for the capacity range,
+// rounded to a whole number of extents
+func volumeSize(capRange *csi.CapacityRange, extentSize uint64) (uint64, error) {
+	required := capRange.GetRequiredBytes()
+	limit := capRange.GetLimitBytes()
+
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+
+	if limit > 0 && required > limit {
+		return 0, status.Errorf(codes.InvalidArgument, "required bytes %d exceed limit bytes %d", required, limit)
+	}
+
+	var size uint64
+	switch {
+	case required > 0:
+		size = (uint64(required) + extentSize - 1) / extentSize * extentSize
+	case limit > 0:
+		size = uint64(limit) / extentSize * extentSize
+	default:
+		size = (defaultVolumeSize + extentSize - 1) / extentSize * extentSize
+	}
+
+	if size == 0 || (limit > 0 && size > uint64(limit)) {
+		return 0, status.Errorf(codes.OutOfRange, "capacity range %v cannot be satisfied with extents of %d bytes", capRange, extentSize)
+	}
+
+	return size, nil
+}
+
+// sizeInRange reports whether an existing volume of size bytes satisfies the capacity range
+func sizeInRange(size uint64, capRange *csi.CapacityRange) bool {
+	if size < uint64(capRange.GetRequiredBytes()) {
+		return false
+	}
+
+	return capRange.GetLimitBytes() == 0 || size <= uint64(capRange.GetLimitBytes())
+}
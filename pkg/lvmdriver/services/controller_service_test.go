@@ -0,0 +1,686 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	mib        = 1 << 20
+	extentSize = 4 * mib
+	vgSize     = 1024 * mib
+)
+
+// newFakeVolumeGroup returns an lvm backend holding an empty 1GiB volume group vg0
+func newFakeVolumeGroup() *lvm.Fake {
+	fakeLvm := lvm.NewFake()
+	fakeLvm.AddVolumeGroup("vg0", vgSize, extentSize)
+	return fakeLvm
+}
+
+// newDeviceClasses returns the given device classes, or else the single
+// device class "default" provisioning volumes in vg0
+func newDeviceClasses(t *testing.T, classes ...*services.DeviceClass) *services.DeviceClasses {
+	if len(classes) == 0 {
+		classes = append(classes, &services.DeviceClass{Name: "default", VolumeGroup: "vg0"})
+	}
+
+	deviceClasses, err := services.NewDeviceClasses(classes)
+	assert.NoError(t, err)
+	return deviceClasses
+}
+
+// createLogicalVolume adds a volume of size bytes with the given tags to vg0
+func createLogicalVolume(t *testing.T, fakeLvm *lvm.Fake, name string, size uint64, tags ...string) {
+	err := fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{VG: "vg0", Name: name, Size: size, Tags: tags})
+	assert.NoError(t, err)
+}
+
+func TestControllerGetCapabilities(t *testing.T) {
+	validCapabilities := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+	}
+
+	controllerSvc := services.NewControllerService("ControllerGetCapabilitiesSvc", "node_001", newDeviceClasses(t), nil, nil, nil)
+	req := &csi.ControllerGetCapabilitiesRequest{}
+
+	resp, err := controllerSvc.ControllerGetCapabilities(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	returnedCapabilities := make([]csi.ControllerServiceCapability_RPC_Type, 0, len(resp.Capabilities))
+
+	for _, cap := range resp.Capabilities {
+		returnedCapabilities = append(returnedCapabilities, cap.GetRpc().GetType())
+	}
+
+	// Make sure all valid and only valid capabilities were returned
+	assert.ElementsMatch(t, returnedCapabilities, validCapabilities)
+}
+
+func TestCreateVolumeInvalidArgs(t *testing.T) {
+	tests := []struct {
+		desc         string
+		req          *csi.CreateVolumeRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc: "missing name",
+			req: &csi.CreateVolumeRequest{
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "invalid logical volume name",
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc/1",
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing capabilities",
+			req: &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "other node requested",
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Requisite: []*csi.Topology{
+						{Segments: map[string]string{"topology.CreateVolumeSvc/node": "node_002"}},
+					},
+				},
+			},
+			expectedCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), nil, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), test.req)
+			assert.Nil(t, resp)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestCreateVolume(t *testing.T) {
+	tests := []struct {
+		desc         string
+		capRange     *csi.CapacityRange
+		used         uint64
+		expectedSize int64
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "default size",
+			expectedSize: 1024 * mib,
+		},
+		{
+			desc:         "required bytes rounded up to extent",
+			capRange:     &csi.CapacityRange{RequiredBytes: 10*mib + 1},
+			expectedSize: 12 * mib,
+		},
+		{
+			desc:         "limit bytes rounded down to extent",
+			capRange:     &csi.CapacityRange{LimitBytes: 10 * mib},
+			expectedSize: 8 * mib,
+		},
+		{
+			desc:         "rounded size exceeds limit",
+			capRange:     &csi.CapacityRange{RequiredBytes: 9 * mib, LimitBytes: 10 * mib},
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			desc:         "required exceeds limit",
+			capRange:     &csi.CapacityRange{RequiredBytes: 20 * mib, LimitBytes: 10 * mib},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "insufficient free space",
+			capRange:     &csi.CapacityRange{RequiredBytes: 20 * mib},
+			used:         vgSize - 16*mib,
+			expectedCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			if test.used > 0 {
+				createLogicalVolume(t, fakeLvm, "used", test.used)
+			}
+			controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      test.capRange,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				_, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+				assert.ErrorIs(t, err, lvm.ErrNotFound, "no volume should be created")
+				return
+			}
+
+			assert.Equal(t, "vg0/pvc-1", resp.Volume.VolumeId)
+			assert.Equal(t, test.expectedSize, resp.Volume.CapacityBytes)
+			assert.Equal(t, "node_001", resp.Volume.AccessibleTopology[0].Segments["topology.CreateVolumeSvc/node"])
+			assert.Equal(t, "true", resp.Volume.AccessibleTopology[0].Segments["topology.CreateVolumeSvc/deviceclass-default"])
+			assert.Equal(t, map[string]string{"deviceClass": "default"}, resp.Volume.VolumeContext)
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(test.expectedSize), lv.Size)
+			assert.Equal(t, []string{
+				"CreateVolumeSvc/name=pvc-1",
+				fmt.Sprintf("CreateVolumeSvc/capacity=%d:%d", test.capRange.GetRequiredBytes(), test.capRange.GetLimitBytes()),
+				"CreateVolumeSvc/deviceclass=default",
+			}, lv.Tags)
+		})
+	}
+}
+
+func TestCreateVolumeExisting(t *testing.T) {
+	// {"type":"fast"} encoded the way the controller records parameters
+	paramsTag := "CreateVolumeSvc/params=eyJ0eXBlIjoiZmFzdCJ9"
+	managedTags := []string{"CreateVolumeSvc/name=pvc-1", "CreateVolumeSvc/capacity=0:0", paramsTag}
+
+	tests := []struct {
+		desc         string
+		lvName       string
+		lvTags       []string
+		capRange     *csi.CapacityRange
+		params       map[string]string
+		expectedId   string
+		expectedCode codes.Code
+		expectCreate bool
+	}{
+		{
+			desc:       "matching request",
+			lvName:     "pvc-1",
+			lvTags:     managedTags,
+			capRange:   &csi.CapacityRange{RequiredBytes: 8 * mib},
+			params:     map[string]string{"type": "fast"},
+			expectedId: "vg0/pvc-1",
+		},
+		{
+			desc:         "conflicting size",
+			lvName:       "pvc-1",
+			lvTags:       managedTags,
+			capRange:     &csi.CapacityRange{RequiredBytes: 16 * mib},
+			params:       map[string]string{"type": "fast"},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			desc:         "conflicting parameters",
+			lvName:       "pvc-1",
+			lvTags:       managedTags,
+			capRange:     &csi.CapacityRange{RequiredBytes: 8 * mib},
+			params:       map[string]string{"type": "slow"},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			desc:         "unmanaged volume with the same name",
+			lvName:       "pvc-1",
+			capRange:     &csi.CapacityRange{RequiredBytes: 8 * mib},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			desc:         "tags of another driver",
+			lvName:       "other",
+			lvTags:       []string{"OtherDriver/name=pvc-1"},
+			capRange:     &csi.CapacityRange{RequiredBytes: 8 * mib},
+			expectedId:   "vg0/pvc-1",
+			expectCreate: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			createLogicalVolume(t, fakeLvm, test.lvName, 8*mib, test.lvTags...)
+			controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      test.capRange,
+				Parameters:         test.params,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			lvs, err := fakeLvm.ListLogicalVolumes(context.Background(), "vg0")
+			assert.NoError(t, err)
+			if test.expectCreate {
+				assert.Len(t, lvs, 2)
+			} else {
+				assert.Len(t, lvs, 1, "no volume should be created")
+			}
+
+			if test.expectedCode == codes.OK {
+				assert.Equal(t, test.expectedId, resp.Volume.VolumeId)
+				assert.Equal(t, int64(8*mib), resp.Volume.CapacityBytes)
+			}
+		})
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	tests := []struct {
+		desc         string
+		volumeId     string
+		lvTags       []string
+		expectedCode codes.Code
+		expectRemove bool
+	}{
+		{
+			desc:         "existing volume",
+			volumeId:     "vg0/pvc-1",
+			lvTags:       []string{"DeleteVolumeSvc/name=pvc-1"},
+			expectRemove: true,
+		},
+		{
+			desc:     "missing volume",
+			volumeId: "vg0/pvc-2",
+		},
+		{
+			desc:         "unmanaged volume",
+			volumeId:     "vg0/pvc-1",
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "missing volume id",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "other volume group",
+			volumeId:     "vg1/pvc-1",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			createLogicalVolume(t, fakeLvm, "pvc-1", 8*mib, test.lvTags...)
+			controllerSvc := services.NewControllerService("DeleteVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			_, err := controllerSvc.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: test.volumeId})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+			if test.expectRemove {
+				assert.ErrorIs(t, err, lvm.ErrNotFound)
+			} else {
+				assert.NoError(t, err, "no volume should be removed")
+			}
+		})
+	}
+}
+
+func TestCreateVolumeLvmFailure(t *testing.T) {
+	tests := []struct {
+		desc         string
+		stderr       string
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "insufficient free space",
+			stderr:       `  Volume group "vg0" has insufficient free space (0 extents): 2 required.`,
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:         "volume exists",
+			stderr:       `  Logical Volume "pvc-1" already exists in volume group "vg0"`,
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			desc:         "lock contention",
+			stderr:       "  Giving up waiting for lock.\n  Can't get lock for vg0",
+			expectedCode: codes.Aborted,
+		},
+		{
+			desc:         "unknown failure",
+			stderr:       `  device-mapper: reload ioctl on (253:3) failed: Device or resource busy`,
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			fakeLvm.FailNext("lvcreate", test.stderr)
+			controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 8 * mib},
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.Contains(t, status.Convert(err).Message(), "failed to create volume pvc-1")
+			assert.Nil(t, resp)
+		})
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	tests := []struct {
+		desc              string
+		req               *csi.GetCapacityRequest
+		expectedCapacity  int64
+		expectedMaxVolume int64
+		expectedCode      codes.Code
+	}{
+		{
+			desc:              "volume group",
+			req:               &csi.GetCapacityRequest{},
+			expectedCapacity:  vgSize - 520*mib,
+			expectedMaxVolume: vgSize - 520*mib,
+		},
+		{
+			desc: "this node",
+			req: &csi.GetCapacityRequest{
+				AccessibleTopology: &csi.Topology{Segments: map[string]string{"topology.GetCapacitySvc/node": "node_001"}},
+			},
+			expectedCapacity:  vgSize - 520*mib,
+			expectedMaxVolume: vgSize - 520*mib,
+		},
+		{
+			desc: "other node",
+			req: &csi.GetCapacityRequest{
+				AccessibleTopology: &csi.Topology{Segments: map[string]string{"topology.GetCapacitySvc/node": "node_002"}},
+			},
+		},
+		{
+			desc: "unsupported capabilities",
+			req: &csi.GetCapacityRequest{
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+					},
+				},
+			},
+		},
+		{
+			desc:              "thin pool",
+			req:               &csi.GetCapacityRequest{Parameters: map[string]string{"thinPool": "pool0"}},
+			expectedCapacity:  512 * mib,
+			expectedMaxVolume: 512 * mib,
+		},
+		{
+			desc:              "overprovisioned thin pool",
+			req:               &csi.GetCapacityRequest{Parameters: map[string]string{"thinPool": "pool0", "overprovisionRatio": "2.5"}},
+			expectedCapacity:  1280 * mib,
+			expectedMaxVolume: 1280 * mib,
+		},
+		{
+			desc: "unknown device class",
+			req:  &csi.GetCapacityRequest{Parameters: map[string]string{"deviceClass": "fast"}},
+		},
+		{
+			desc:         "missing thin pool",
+			req:          &csi.GetCapacityRequest{Parameters: map[string]string{"thinPool": "pool1"}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid overprovision ratio",
+			req:          &csi.GetCapacityRequest{Parameters: map[string]string{"thinPool": "pool0", "overprovisionRatio": "0.5"}},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			assert.NoError(t, fakeLvm.AddThinPool("vg0", "pool0", 512*mib))
+			createLogicalVolume(t, fakeLvm, "pvc-1", 8*mib)
+			controllerSvc := services.NewControllerService("GetCapacitySvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.GetCapacity(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.Equal(t, test.expectedCapacity, resp.AvailableCapacity)
+			if test.expectedCapacity > 0 {
+				assert.Equal(t, test.expectedMaxVolume, resp.MaximumVolumeSize.GetValue())
+				assert.Equal(t, int64(extentSize), resp.MinimumVolumeSize.GetValue())
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	tests := []struct {
+		desc                  string
+		req                   *csi.ControllerExpandVolumeRequest
+		expectedSize          int64
+		expectedNodeExpansion bool
+		expectedCode          codes.Code
+	}{
+		{
+			desc: "grow filesystem volume",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:         "vg0/pvc-1",
+				CapacityRange:    &csi.CapacityRange{RequiredBytes: 15 * mib},
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedSize:          16 * mib,
+			expectedNodeExpansion: true,
+		},
+		{
+			desc: "grow block volume",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:         "vg0/pvc-1",
+				CapacityRange:    &csi.CapacityRange{RequiredBytes: 16 * mib},
+				VolumeCapability: blockCapability(),
+			},
+			expectedSize: 16 * mib,
+		},
+		{
+			desc: "already expanded",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "vg0/pvc-1",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 4 * mib},
+			},
+			expectedSize:          8 * mib,
+			expectedNodeExpansion: true,
+		},
+		{
+			desc: "insufficient free space",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "vg0/pvc-1",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * vgSize},
+			},
+			expectedSize: 8 * mib,
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc: "missing volume",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "vg0/pvc-2",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 16 * mib},
+			},
+			expectedSize: 8 * mib,
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc: "missing capacity range",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "vg0/pvc-1",
+			},
+			expectedSize: 8 * mib,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "other volume group",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "vg1/pvc-1",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 16 * mib},
+			},
+			expectedSize: 8 * mib,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			createLogicalVolume(t, fakeLvm, "pvc-1", 8*mib, "ControllerExpandVolumeSvc/name=pvc-1")
+			controllerSvc := services.NewControllerService("ControllerExpandVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.ControllerExpandVolume(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			lv, lvErr := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+			assert.NoError(t, lvErr)
+			assert.Equal(t, uint64(test.expectedSize), lv.Size)
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.Equal(t, test.expectedSize, resp.CapacityBytes)
+			assert.Equal(t, test.expectedNodeExpansion, resp.NodeExpansionRequired)
+		})
+	}
+}
+
+func TestCreateThinVolume(t *testing.T) {
+	tests := []struct {
+		desc         string
+		params       map[string]string
+		size         int64
+		expectedCode codes.Code
+	}{
+		{
+			desc:   "fits in the pool",
+			params: map[string]string{"thinPool": "pool0"},
+			size:   32 * mib,
+		},
+		{
+			desc:         "exceeds the pool",
+			params:       map[string]string{"thinPool": "pool0"},
+			size:         48 * mib,
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:   "overprovisioned",
+			params: map[string]string{"thinPool": "pool0", "overprovisionRatio": "4"},
+			size:   200 * mib,
+		},
+		{
+			desc:         "exceeds the overprovision ratio",
+			params:       map[string]string{"thinPool": "pool0", "overprovisionRatio": "4"},
+			size:         240 * mib,
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:         "missing pool",
+			params:       map[string]string{"thinPool": "pool1"},
+			size:         8 * mib,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			assert.NoError(t, fakeLvm.AddThinPool("vg0", "pool0", 64*mib))
+			assert.NoError(t, fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{VG: "vg0", Name: "thin0", Size: 32 * mib, Pool: "pool0"}))
+			controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: test.size},
+				Parameters:         test.params,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+			assert.NoError(t, err)
+			assert.Equal(t, "pool0", lv.Pool)
+			assert.Equal(t, uint64(test.size), lv.Size)
+
+			// Thin volumes leave the free space of the volume group untouched
+			vg, err := fakeLvm.GetVolumeGroup(context.Background(), "vg0")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(vgSize-64*mib), vg.Free)
+		})
+	}
+}
+
+func TestExpandThinVolume(t *testing.T) {
+	fakeLvm := newFakeVolumeGroup()
+	assert.NoError(t, fakeLvm.AddThinPool("vg0", "pool0", 64*mib))
+	controllerSvc := services.NewControllerService("ExpandThinVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+	_, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 32 * mib},
+		Parameters:         map[string]string{"thinPool": "pool0", "overprovisionRatio": "2"},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	assert.NoError(t, err)
+
+	// The ratio the volume was created with still applies
+	resp, err := controllerSvc.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "vg0/pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 128 * mib},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(128*mib), resp.CapacityBytes)
+
+	_, err = controllerSvc.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "vg0/pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 132 * mib},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	capacity, err := controllerSvc.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{"thinPool": "pool0", "overprovisionRatio": "3"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(64*mib), capacity.AvailableCapacity)
+}
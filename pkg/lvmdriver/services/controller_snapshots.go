@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+)
+
+// CreateSnapshot takes a thin snapshot of a thin volume created by the driver,
+// or a copy-on-write snapshot of a thick one with a store sized by the
+// snapshotReserve parameter. The snapshot is identified by its volume group
+// and logical volume like volumes are. Retries for a copy-on-write snapshot
+// that overflowed its store fail with FailedPrecondition.
+func (c *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name is missing from the request")
+	}
+
+	if !lvNameRegexp.MatchString(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot name %s is not a valid logical volume name", name)
+	}
+
+	sourceId := req.GetSourceVolumeId()
+	if sourceId == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume id is missing from the request")
+	}
+
+	vg, source, err := utils.ParseVolumeID(sourceId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := c.checkVolumeGroup(sourceId, vg); err != nil {
+		return nil, err
+	}
+
+	// Parse the parameters early so invalid ones fail before touching lvm
+	if _, err := parseVolumeParameters(req.GetParameters()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	tags := &snapshotTags{name: name, sourceVolumeId: sourceId}
+	lvmTags, err := tags.lvmTags(c.driverName)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// The snapshot tag identifies the snapshot created for a request so
+	// retries return the existing snapshot instead of taking another
+	lvs, err := c.lvm.ListLogicalVolumes(ctx, vg)
+	if err != nil {
+		return nil, lvmError(err, "failed to list volumes in volume group %s", vg)
+	}
+
+	var origin *lvm.LogicalVolume
+	for _, lv := range lvs {
+		if lv.Name == source {
+			origin = lv
+		}
+
+		existing := parseSnapshotTags(c.driverName, lv)
+		if existing == nil {
+			if lv.Name == name {
+				return nil, status.Errorf(codes.AlreadyExists, "logical volume %s exists but is not a snapshot of %s", name, c.driverName)
+			}
+			continue
+		}
+
+		if existing.name != name {
+			continue
+		}
+
+		if existing.sourceVolumeId != sourceId {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for volume %s", name, existing.sourceVolumeId)
+		}
+
+		// The snapshotter records the error on the snapshot content
+		if snapshotOverflowed(lv, existing) {
+			return nil, snapshotOverflowError(utils.VolumeID(lv.VG, lv.Name), lv)
+		}
+
+		klog.FromContext(ctx).V(2).Info("snapshot already exists", "name", name, "id", utils.VolumeID(lv.VG, lv.Name))
+		return &csi.CreateSnapshotResponse{Snapshot: c.csiSnapshot(lv, existing)}, nil
+	}
+
+	if origin == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist", sourceId)
+	}
+
+	originTags := parseVolumeTags(c.driverName, origin)
+	if originTags == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not managed by %s", sourceId, c.driverName)
+	}
+
+	// Thin snapshots share the pool of their origin and take no size
+	var reserve uint64
+	if origin.Pool == "" {
+		reserve, err = c.snapshotReserveSize(ctx, origin, originTags, req.GetParameters())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	klog.FromContext(ctx).V(2).Info("creating snapshot", "name", name, "source", sourceId, "size", reserve)
+	err = c.lvm.CreateLogicalVolume(ctx, lvm.CreateOptions{
+		VG:     vg,
+		Name:   name,
+		Size:   reserve,
+		Origin: source,
+		Tags:   lvmTags,
+	})
+	if err != nil {
+		return nil, lvmError(err, "failed to create snapshot %s", name)
+	}
+
+	snapshot, err := c.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		return nil, lvmError(err, "failed to look up snapshot %s", name)
+	}
+
+	return &csi.CreateSnapshotResponse{Snapshot: c.csiSnapshot(snapshot, tags)}, nil
+}
+
+func (c *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if req.GetSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetSnapshotId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := c.checkVolumeGroup(req.GetSnapshotId(), vg); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	lv, err := c.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			klog.FromContext(ctx).V(2).Info("snapshot is already removed", "snapshot_id", req.GetSnapshotId())
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, lvmError(err, "failed to look up snapshot %s", req.GetSnapshotId())
+	}
+
+	if parseSnapshotTags(c.driverName, lv) == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not a snapshot of %s", req.GetSnapshotId(), c.driverName)
+	}
+
+	klog.FromContext(ctx).V(2).Info("removing snapshot", "snapshot_id", req.GetSnapshotId())
+	if err := c.lvm.RemoveLogicalVolume(ctx, vg, name); err != nil && !errors.Is(err, lvm.ErrNotFound) {
+		return nil, lvmError(err, "failed to remove snapshot %s", req.GetSnapshotId())
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots lists the snapshots of the driver in the volume groups of the
+// device classes, sorted by id. The starting token is the index of the first
+// entry to return.
+func (c *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max entries must not be negative")
+	}
+
+	start, err := parseStartingToken(req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	lvs, err := c.listLogicalVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, lv := range lvs {
+		tags := parseSnapshotTags(c.driverName, lv)
+		if tags == nil {
+			continue
+		}
+
+		snapshot := c.csiSnapshot(lv, tags)
+		if req.GetSnapshotId() != "" && req.GetSnapshotId() != snapshot.SnapshotId {
+			continue
+		}
+		if req.GetSourceVolumeId() != "" && req.GetSourceVolumeId() != snapshot.SourceVolumeId {
+			continue
+		}
+
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+
+	if start > len(entries) {
+		return nil, status.Errorf(codes.Aborted, "starting token %d is past the %d snapshots", start, len(entries))
+	}
+	entries = entries[start:]
+
+	resp := &csi.ListSnapshotsResponse{}
+	if limit := int(req.GetMaxEntries()); limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+		resp.NextToken = strconv.Itoa(start + limit)
+	}
+	resp.Entries = entries
+
+	return resp, nil
+}
+
+// parseStartingToken returns the index of the first entry of a page, the
+// starting token of list requests
+func parseStartingToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	start, err := strconv.Atoi(token)
+	if err != nil || start < 0 {
+		return 0, status.Errorf(codes.Aborted, "invalid starting token %s", token)
+	}
+
+	return start, nil
+}
+
+// listLogicalVolumes returns the logical volumes in the volume groups of the
+// device classes, sorted by volume group and name so pages are stable
+func (c *ControllerService) listLogicalVolumes(ctx context.Context) ([]*lvm.LogicalVolume, error) {
+	var lvs []*lvm.LogicalVolume
+	for _, vg := range c.deviceClasses.VolumeGroups() {
+		vgLvs, err := c.lvm.ListLogicalVolumes(ctx, vg)
+		if err != nil {
+			return nil, lvmError(err, "failed to list volumes in volume group %s", vg)
+		}
+		lvs = append(lvs, vgLvs...)
+	}
+
+	sort.Slice(lvs, func(i, j int) bool {
+		if lvs[i].VG != lvs[j].VG {
+			return lvs[i].VG < lvs[j].VG
+		}
+		return lvs[i].Name < lvs[j].Name
+	})
+
+	return lvs, nil
+}
+
+// snapshotReserveSize returns the size of the store of a copy-on-write
+// snapshot of origin, from the snapshotReserve parameter of the request or
+// else of the StorageClass the origin was created with
+func (c *ControllerService) snapshotReserveSize(ctx context.Context, origin *lvm.LogicalVolume, originTags *volumeTags, snapshotParams map[string]string) (uint64, error) {
+	params := map[string]string{}
+	created, err := originTags.parameters()
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "volume %s/%s has invalid tags: %v", origin.VG, origin.Name, err)
+	}
+	if reserve, ok := created[snapshotReserveParam]; ok {
+		params[snapshotReserveParam] = reserve
+	}
+	if reserve, ok := snapshotParams[snapshotReserveParam]; ok {
+		params[snapshotReserveParam] = reserve
+	}
+
+	volumeParams, err := parseVolumeParameters(params)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	vg, err := c.lvm.GetVolumeGroup(ctx, origin.VG)
+	if err != nil {
+		return 0, lvmError(err, "failed to get volume group %s", origin.VG)
+	}
+
+	// The store of the snapshot leaves the spare gap of the volume group free
+	class := c.volumeClass(origin, originTags)
+
+	reserve := volumeParams.snapshotReserve.size(origin.Size, vg.ExtentSize)
+	if free := freeSpace(vg, class); reserve > free {
+		return 0, status.Errorf(codes.ResourceExhausted, "volume group %s has %d bytes free beyond a spare gap of %d bytes, %d requested for a snapshot of %s/%s",
+			vg.Name, free, class.SpareGap, reserve, origin.VG, origin.Name)
+	}
+
+	return reserve, nil
+}
+
+func (c *ControllerService) csiSnapshot(lv *lvm.LogicalVolume, tags *snapshotTags) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     utils.VolumeID(lv.VG, lv.Name),
+		SourceVolumeId: tags.sourceVolumeId,
+		SizeBytes:      int64(restoreSize(lv)),
+		CreationTime:   timestamppb.New(lv.CreationTime),
+		ReadyToUse:     !snapshotOverflowed(lv, tags),
+	}
+}
+
+// restoreSize returns the size of the content of a volume or snapshot. The
+// size of a copy-on-write snapshot is that of its store, not its content.
+func restoreSize(lv *lvm.LogicalVolume) uint64 {
+	if lv.CopyOnWrite() {
+		return lv.OriginSize
+	}
+
+	return lv.Size
+}
+
+// snapshotOverflowed reports whether a copy-on-write snapshot ran out of
+// space, as reported by lvm or recorded by the SnapshotMonitor. lvm stops
+// reporting the snapshot as invalid once it is deactivated.
+func snapshotOverflowed(lv *lvm.LogicalVolume, tags *snapshotTags) bool {
+	return lv.Invalid() || tags.overflowed
+}
+
+// snapshotOverflowError is returned for the use of an overflowed snapshot
+func snapshotOverflowError(id string, lv *lvm.LogicalVolume) error {
+	return status.Errorf(codes.FailedPrecondition, "snapshot %s overflowed its reserve of %d bytes and is no longer usable, delete it and take another", id, lv.Size)
+}
@@ -0,0 +1,417 @@
+package services_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newFakeThinVolume returns an lvm backend holding the thin volume vg0/pvc-1
+// created by the driver SnapshotSvc, and the thick volume vg0/pvc-2
+func newFakeThinVolume(t *testing.T) *lvm.Fake {
+	fakeLvm := newFakeVolumeGroup()
+	assert.NoError(t, fakeLvm.AddThinPool("vg0", "pool0", 64*mib))
+	assert.NoError(t, fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{
+		VG:   "vg0",
+		Name: "pvc-1",
+		Size: 32 * mib,
+		Pool: "pool0",
+		Tags: []string{"SnapshotSvc/name=pvc-1"},
+	}))
+	createLogicalVolume(t, fakeLvm, "pvc-2", 8*mib, "SnapshotSvc/name=pvc-2")
+
+	return fakeLvm
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	tests := []struct {
+		desc         string
+		req          *csi.CreateSnapshotRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc: "thin volume",
+			req:  &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"},
+		},
+		{
+			desc:         "missing name",
+			req:          &csi.CreateSnapshotRequest{SourceVolumeId: "vg0/pvc-1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing source volume",
+			req:          &csi.CreateSnapshotRequest{Name: "snap-1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "source volume does not exist",
+			req:          &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-3"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc: "snapshot reserve ignored for thin volumes",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "snap-1",
+				SourceVolumeId: "vg0/pvc-1",
+				Parameters:     map[string]string{"snapshotReserve": "50%"},
+			},
+		},
+		{
+			desc: "invalid snapshot reserve",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "snap-1",
+				SourceVolumeId: "vg0/pvc-1",
+				Parameters:     map[string]string{"snapshotReserve": "150%"},
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "name of an existing volume",
+			req:          &csi.CreateSnapshotRequest{Name: "pvc-2", SourceVolumeId: "vg0/pvc-1"},
+			expectedCode: codes.AlreadyExists,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeThinVolume(t)
+			controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateSnapshot(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.Equal(t, "vg0/snap-1", resp.Snapshot.SnapshotId)
+			assert.Equal(t, "vg0/pvc-1", resp.Snapshot.SourceVolumeId)
+			assert.Equal(t, int64(32*mib), resp.Snapshot.SizeBytes)
+			assert.True(t, resp.Snapshot.ReadyToUse)
+			assert.NotZero(t, resp.Snapshot.CreationTime.GetSeconds())
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "snap-1")
+			assert.NoError(t, err)
+			assert.Equal(t, "pvc-1", lv.Origin)
+			assert.ElementsMatch(t, []string{"SnapshotSvc/snapshot=snap-1", "SnapshotSvc/source=vg0/pvc-1"}, lv.Tags)
+		})
+	}
+}
+
+func TestCreateCopyOnWriteSnapshot(t *testing.T) {
+	// The thick volume pvc-4 of 64MiB was created by a StorageClass reserving 25% for snapshots
+	classParams := base64.RawURLEncoding.EncodeToString([]byte(`{"snapshotReserve":"25%"}`))
+
+	tests := []struct {
+		desc            string
+		source          string
+		params          map[string]string
+		expectedCode    codes.Code
+		expectedReserve uint64
+		expectedSize    int64
+	}{
+		{
+			desc:            "default reserve",
+			source:          "vg0/pvc-2",
+			expectedReserve: 4 * mib,
+			expectedSize:    8 * mib,
+		},
+		{
+			desc:            "reserve of the StorageClass",
+			source:          "vg0/pvc-4",
+			expectedReserve: 16 * mib,
+			expectedSize:    64 * mib,
+		},
+		{
+			desc:            "percentage of the VolumeSnapshotClass",
+			source:          "vg0/pvc-4",
+			params:          map[string]string{"snapshotReserve": "50%"},
+			expectedReserve: 32 * mib,
+			expectedSize:    64 * mib,
+		},
+		{
+			desc:            "bytes of the VolumeSnapshotClass",
+			source:          "vg0/pvc-2",
+			params:          map[string]string{"snapshotReserve": "9437184"},
+			expectedReserve: 12 * mib,
+			expectedSize:    8 * mib,
+		},
+		{
+			desc:         "reserve larger than the volume group",
+			source:       "vg0/pvc-2",
+			params:       map[string]string{"snapshotReserve": "2147483648"},
+			expectedCode: codes.ResourceExhausted,
+		},
+		{
+			desc:         "invalid reserve",
+			source:       "vg0/pvc-2",
+			params:       map[string]string{"snapshotReserve": "big"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeThinVolume(t)
+			createLogicalVolume(t, fakeLvm, "pvc-4", 64*mib, "SnapshotSvc/name=pvc-4", "SnapshotSvc/params="+classParams)
+			controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+				Name:           "snap-1",
+				SourceVolumeId: test.source,
+				Parameters:     test.params,
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.Equal(t, test.expectedSize, resp.Snapshot.SizeBytes, "the size should be that of the source volume")
+			assert.True(t, resp.Snapshot.ReadyToUse)
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "snap-1")
+			assert.NoError(t, err)
+			assert.True(t, lv.CopyOnWrite())
+			assert.Equal(t, test.expectedReserve, lv.Size)
+		})
+	}
+}
+
+func TestCopyOnWriteSnapshotOverflow(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+	_, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-2"})
+	assert.NoError(t, err)
+
+	// The monitor records the overflow for when lvm no longer reports it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go services.NewSnapshotMonitor("SnapshotSvc", newDeviceClasses(t), fakeLvm, 10*time.Millisecond).Run(ctx)
+
+	assert.NoError(t, fakeLvm.SetDataPercent("vg0", "snap-1", 100))
+	assert.Eventually(t, func() bool {
+		lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "snap-1")
+		if err != nil {
+			return false
+		}
+		for _, tag := range lv.Tags {
+			if tag == "SnapshotSvc/overflowed=true" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "snap-1", false))
+
+	resp, err := controllerSvc.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: "vg0/snap-1"})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Entries, 1)
+	assert.False(t, resp.Entries[0].Snapshot.ReadyToUse)
+
+	// Retries fail with the reason of the overflow
+	again, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-2"})
+	assert.Nil(t, again)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "snapshot vg0/snap-1 overflowed its reserve of 4194304 bytes")
+
+	_, err = controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:                "pvc-3",
+		VolumeCapabilities:  []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: snapshotSource("vg0/snap-1"),
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "an overflowed snapshot should not be restored")
+	assert.Contains(t, status.Convert(err).Message(), "no longer usable")
+}
+
+func TestDeleteVolumeWithCopyOnWriteSnapshot(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+	_, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-2"})
+	assert.NoError(t, err)
+
+	_, err = controllerSvc.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vg0/pvc-2"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = controllerSvc.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "vg0/snap-1"})
+	assert.NoError(t, err)
+
+	_, err = controllerSvc.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vg0/pvc-2"})
+	assert.NoError(t, err)
+}
+
+func TestCreateSnapshotIdempotent(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+	req := &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"}
+
+	first, err := controllerSvc.CreateSnapshot(context.Background(), req)
+	assert.NoError(t, err)
+
+	second, err := controllerSvc.CreateSnapshot(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Snapshot.SnapshotId, second.Snapshot.SnapshotId)
+	assert.Equal(t, first.Snapshot.CreationTime.AsTime(), second.Snapshot.CreationTime.AsTime())
+
+	// The same name for another volume conflicts
+	assert.NoError(t, fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{
+		VG: "vg0", Name: "pvc-3", Size: 8 * mib, Pool: "pool0", Tags: []string{"SnapshotSvc/name=pvc-3"},
+	}))
+	_, err = controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-3"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	tests := []struct {
+		desc         string
+		snapshotId   string
+		expectedCode codes.Code
+		expectRemove bool
+	}{
+		{
+			desc:         "existing snapshot",
+			snapshotId:   "vg0/snap-1",
+			expectRemove: true,
+		},
+		{
+			desc:       "missing snapshot",
+			snapshotId: "vg0/snap-2",
+		},
+		{
+			desc:         "volume instead of a snapshot",
+			snapshotId:   "vg0/pvc-1",
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:         "missing snapshot id",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "other volume group",
+			snapshotId:   "vg1/snap-1",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeThinVolume(t)
+			controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+			_, err := controllerSvc.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"})
+			assert.NoError(t, err)
+
+			_, err = controllerSvc.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: test.snapshotId})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "snap-1")
+			if test.expectRemove {
+				assert.ErrorIs(t, err, lvm.ErrNotFound)
+			} else {
+				assert.NoError(t, err, "no snapshot should be removed")
+			}
+
+			_, err = fakeLvm.GetLogicalVolume(context.Background(), "vg0", "pvc-1")
+			assert.NoError(t, err, "the source volume should be kept")
+		})
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	assert.NoError(t, fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{
+		VG: "vg0", Name: "pvc-3", Size: 8 * mib, Pool: "pool0", Tags: []string{"SnapshotSvc/name=pvc-3"},
+	}))
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+	for _, req := range []*csi.CreateSnapshotRequest{
+		{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"},
+		{Name: "snap-2", SourceVolumeId: "vg0/pvc-3"},
+		{Name: "snap-3", SourceVolumeId: "vg0/pvc-1"},
+	} {
+		_, err := controllerSvc.CreateSnapshot(context.Background(), req)
+		assert.NoError(t, err)
+	}
+
+	snapshotIds := func(resp *csi.ListSnapshotsResponse) []string {
+		var ids []string
+		for _, entry := range resp.Entries {
+			ids = append(ids, entry.Snapshot.SnapshotId)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		desc          string
+		req           *csi.ListSnapshotsRequest
+		expectedIds   []string
+		expectedToken string
+		expectedCode  codes.Code
+	}{
+		{
+			desc:        "all snapshots",
+			req:         &csi.ListSnapshotsRequest{},
+			expectedIds: []string{"vg0/snap-1", "vg0/snap-2", "vg0/snap-3"},
+		},
+		{
+			desc:        "by snapshot id",
+			req:         &csi.ListSnapshotsRequest{SnapshotId: "vg0/snap-2"},
+			expectedIds: []string{"vg0/snap-2"},
+		},
+		{
+			desc: "unknown snapshot id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: "vg0/snap-4"},
+		},
+		{
+			desc:        "by source volume",
+			req:         &csi.ListSnapshotsRequest{SourceVolumeId: "vg0/pvc-1"},
+			expectedIds: []string{"vg0/snap-1", "vg0/snap-3"},
+		},
+		{
+			desc:          "first page",
+			req:           &csi.ListSnapshotsRequest{MaxEntries: 2},
+			expectedIds:   []string{"vg0/snap-1", "vg0/snap-2"},
+			expectedToken: "2",
+		},
+		{
+			desc:        "last page",
+			req:         &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: "2"},
+			expectedIds: []string{"vg0/snap-3"},
+		},
+		{
+			desc:         "invalid token",
+			req:          &csi.ListSnapshotsRequest{StartingToken: "next"},
+			expectedCode: codes.Aborted,
+		},
+		{
+			desc:         "token past the end",
+			req:          &csi.ListSnapshotsRequest{StartingToken: "4"},
+			expectedCode: codes.Aborted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := controllerSvc.ListSnapshots(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				return
+			}
+
+			assert.Equal(t, test.expectedIds, snapshotIds(resp))
+			assert.Equal(t, test.expectedToken, resp.NextToken)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListVolumes lists the volumes of the driver in the volume groups of the
+// device classes, sorted by id, along with their condition and the node if
+// they are staged or published on it. The starting token is the index of the
+// first entry to return.
+func (c *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max entries must not be negative")
+	}
+
+	start, err := parseStartingToken(req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	lvs, err := c.listLogicalVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Thin pools are listed along with their volumes
+	pools := map[string]*lvm.LogicalVolume{}
+	for _, lv := range lvs {
+		if lv.ThinPool() {
+			pools[utils.VolumeID(lv.VG, lv.Name)] = lv
+		}
+	}
+
+	var entries []*csi.ListVolumesResponse_Entry
+	for _, lv := range lvs {
+		tags := parseVolumeTags(c.driverName, lv)
+		// Volumes still being populated are not created yet
+		if tags == nil || tags.populating {
+			continue
+		}
+
+		nodes, err := c.publishedNodes(lv)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: c.csiVolume(lv, tags),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: nodes,
+				VolumeCondition:  volumeCondition(lv, pools[utils.VolumeID(lv.VG, lv.Pool)]),
+			},
+		})
+	}
+
+	if start > len(entries) {
+		return nil, status.Errorf(codes.Aborted, "starting token %d is past the %d volumes", start, len(entries))
+	}
+	entries = entries[start:]
+
+	resp := &csi.ListVolumesResponse{}
+	if limit := int(req.GetMaxEntries()); limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+		resp.NextToken = strconv.Itoa(start + limit)
+	}
+	resp.Entries = entries
+
+	return resp, nil
+}
+
+// ControllerGetVolume returns a volume of the driver along with its condition
+// and the node if it is staged or published on it
+func (c *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := c.checkVolumeGroup(req.GetVolumeId(), vg); err != nil {
+		return nil, err
+	}
+
+	lv, err := c.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "volume %s does not exist", req.GetVolumeId())
+		}
+		return nil, lvmError(err, "failed to look up volume %s", req.GetVolumeId())
+	}
+
+	tags := parseVolumeTags(c.driverName, lv)
+	if tags == nil || tags.populating {
+		return nil, status.Errorf(codes.NotFound, "volume %s is not a volume of %s", req.GetVolumeId(), c.driverName)
+	}
+
+	var pool *lvm.LogicalVolume
+	if lv.Pool != "" {
+		pool, err = c.lvm.GetLogicalVolume(ctx, vg, lv.Pool)
+		if err != nil && !errors.Is(err, lvm.ErrNotFound) {
+			return nil, lvmError(err, "failed to look up thin pool %s/%s", vg, lv.Pool)
+		}
+	}
+
+	nodes, err := c.publishedNodes(lv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: c.csiVolume(lv, tags),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: nodes,
+			VolumeCondition:  volumeCondition(lv, pool),
+		},
+	}, nil
+}
+
+// publishedNodes returns the node the controller runs on if the node service
+// has the volume staged or published. Logical volumes are only accessible on
+// their node, so no other node can have it published.
+func (c *ControllerService) publishedNodes(lv *lvm.LogicalVolume) ([]string, error) {
+	if c.published == nil {
+		return nil, nil
+	}
+
+	published, err := c.published.Published(lv.VG, lv.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check whether volume %s is published: %v", utils.VolumeID(lv.VG, lv.Name), err)
+	}
+	if !published {
+		return nil, nil
+	}
+
+	return []string{c.nodeId}, nil
+}
+
+// csiVolume describes a volume of the driver as CreateVolume returned it
+func (c *ControllerService) csiVolume(lv *lvm.LogicalVolume, tags *volumeTags) *csi.Volume {
+	return c.createVolumeResponse(c.volumeClass(lv, tags), lv.Name, lv.Size, contentSource(tags.content)).Volume
+}
+
+// volumeCondition derives the condition of a volume from the attributes lvm
+// reports for it and for its thin pool, if any
+func volumeCondition(lv *lvm.LogicalVolume, pool *lvm.LogicalVolume) *csi.VolumeCondition {
+	var problems []string
+	if lv.Partial() {
+		problems = append(problems, "one or more physical volumes of the logical volume are missing")
+	}
+
+	if lv.Suspended() {
+		problems = append(problems, "the logical volume is suspended")
+	}
+
+	if lv.OutOfSync() {
+		problems = append(problems, fmt.Sprintf("the images of the logical volume are out of sync, %.2f%% in sync", lv.SyncPercent))
+	}
+
+	switch {
+	case lv.Pool == "":
+	case pool == nil:
+		problems = append(problems, fmt.Sprintf("thin pool %s does not exist", lv.Pool))
+	case pool.Exhausted():
+		problems = append(problems, fmt.Sprintf("thin pool %s is full or failed, data %.2f%% and metadata %.2f%% used",
+			lv.Pool, pool.DataPercent, pool.MetadataPercent))
+	}
+
+	if len(problems) == 0 {
+		return healthyCondition()
+	}
+
+	return abnormalCondition("%s", strings.Join(problems, "; "))
+}
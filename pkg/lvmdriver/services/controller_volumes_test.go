@@ -0,0 +1,255 @@
+package services_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListVolumes(t *testing.T) {
+	fakeLvm := newFakeThinVolume(t)
+	createLogicalVolume(t, fakeLvm, "pvc-3", 8*mib, "SnapshotSvc/name=pvc-3", "SnapshotSvc/populating=true")
+	createLogicalVolume(t, fakeLvm, "other", 8*mib)
+	assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "pvc-2", false))
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+	volumeIds := func(resp *csi.ListVolumesResponse) []string {
+		var ids []string
+		for _, entry := range resp.Entries {
+			ids = append(ids, entry.Volume.VolumeId)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		desc          string
+		req           *csi.ListVolumesRequest
+		expectedIds   []string
+		expectedToken string
+		expectedCode  codes.Code
+	}{
+		{
+			desc:        "all volumes",
+			req:         &csi.ListVolumesRequest{},
+			expectedIds: []string{"vg0/pvc-1", "vg0/pvc-2"},
+		},
+		{
+			desc:          "first page",
+			req:           &csi.ListVolumesRequest{MaxEntries: 1},
+			expectedIds:   []string{"vg0/pvc-1"},
+			expectedToken: "1",
+		},
+		{
+			desc:        "last page",
+			req:         &csi.ListVolumesRequest{MaxEntries: 1, StartingToken: "1"},
+			expectedIds: []string{"vg0/pvc-2"},
+		},
+		{
+			desc:         "negative max entries",
+			req:          &csi.ListVolumesRequest{MaxEntries: -1},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid token",
+			req:          &csi.ListVolumesRequest{StartingToken: "next"},
+			expectedCode: codes.Aborted,
+		},
+		{
+			desc:         "token past the end",
+			req:          &csi.ListVolumesRequest{StartingToken: "3"},
+			expectedCode: codes.Aborted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := controllerSvc.ListVolumes(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				return
+			}
+
+			assert.Equal(t, test.expectedIds, volumeIds(resp))
+			assert.Equal(t, test.expectedToken, resp.NextToken)
+		})
+	}
+
+	t.Run("status", func(t *testing.T) {
+		resp, err := controllerSvc.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Entries, 2)
+
+		active, inactive := resp.Entries[0], resp.Entries[1]
+		assert.False(t, active.Status.VolumeCondition.Abnormal)
+		assert.Equal(t, int64(32*mib), active.Volume.CapacityBytes)
+		assert.False(t, inactive.Status.VolumeCondition.Abnormal)
+
+		// Active volumes are not necessarily staged
+		assert.Empty(t, active.Status.PublishedNodeIds)
+		assert.Empty(t, inactive.Status.PublishedNodeIds)
+	})
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	tests := []struct {
+		desc             string
+		volumeId         string
+		setup            func(t *testing.T, fakeLvm *lvm.Fake)
+		expectedAbnormal bool
+		expectedMessage  string
+		expectedCode     codes.Code
+	}{
+		{
+			desc:            "healthy thin volume",
+			volumeId:        "vg0/pvc-1",
+			expectedMessage: "volume is healthy",
+		},
+		{
+			desc:     "inactive volume",
+			volumeId: "vg0/pvc-2",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "pvc-2", false))
+			},
+			expectedMessage: "volume is healthy",
+		},
+		{
+			desc:     "partial volume",
+			volumeId: "vg0/pvc-2",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				assert.NoError(t, fakeLvm.SetAttr("vg0", "pvc-2", "-wi-a---p-"))
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "one or more physical volumes of the logical volume are missing",
+		},
+		{
+			desc:     "suspended volume",
+			volumeId: "vg0/pvc-2",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				assert.NoError(t, fakeLvm.SetAttr("vg0", "pvc-2", "-wi-s-----"))
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "the logical volume is suspended",
+		},
+		{
+			desc:     "full thin pool",
+			volumeId: "vg0/pvc-1",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				assert.NoError(t, fakeLvm.SetDataPercent("vg0", "pool0", 100))
+			},
+			expectedAbnormal: true,
+			expectedMessage:  "thin pool pool0 is full or failed, data 100.00% and metadata 0.00% used",
+		},
+		{
+			desc:     "populating volume",
+			volumeId: "vg0/pvc-3",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				createLogicalVolume(t, fakeLvm, "pvc-3", 8*mib, "SnapshotSvc/name=pvc-3", "SnapshotSvc/populating=true")
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:     "volume of another driver",
+			volumeId: "vg0/other",
+			setup: func(t *testing.T, fakeLvm *lvm.Fake) {
+				createLogicalVolume(t, fakeLvm, "other", 8*mib)
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "missing volume",
+			volumeId:     "vg0/pvc-4",
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "unknown volume group",
+			volumeId:     "vg1/pvc-1",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "invalid volume id",
+			volumeId:     "pvc-1",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing volume id",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeThinVolume(t)
+			if test.setup != nil {
+				test.setup(t, fakeLvm)
+			}
+			controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: test.volumeId})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				return
+			}
+
+			assert.Equal(t, test.volumeId, resp.Volume.VolumeId)
+			assert.Equal(t, test.expectedAbnormal, resp.Status.VolumeCondition.Abnormal)
+			assert.Equal(t, test.expectedMessage, resp.Status.VolumeCondition.Message)
+		})
+	}
+}
+
+func TestControllerGetVolumeCreated(t *testing.T) {
+	fakeLvm := newFakeVolumeGroup()
+	_, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("CreateVolumeSvc", "node_001", newDeviceClasses(t), mounter, fakeLvm)
+	controllerSvc := services.NewControllerService("CreateVolumeSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nodeSvc)
+
+	created, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 8 * mib},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	volumeId := created.Volume.VolumeId
+
+	publishedNodes := func() []string {
+		resp, err := controllerSvc.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeId})
+		assert.NoError(t, err)
+		assert.Equal(t, "vg0/pvc-1", resp.Volume.VolumeId)
+		assert.False(t, resp.Status.VolumeCondition.Abnormal)
+
+		list, err := controllerSvc.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+		assert.NoError(t, err)
+		if assert.Len(t, list.Entries, 1) {
+			assert.Equal(t, resp.Status.PublishedNodeIds, list.Entries[0].Status.PublishedNodeIds)
+		}
+
+		return resp.Status.PublishedNodeIds
+	}
+
+	// lvcreate activates the volume, it is not staged on any node yet
+	assert.Empty(t, publishedNodes())
+
+	staging := filepath.Join(t.TempDir(), "staging")
+	_, err = nodeSvc.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeId,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node_001"}, publishedNodes())
+
+	_, err = nodeSvc.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: volumeId, StagingTargetPath: staging})
+	assert.NoError(t, err)
+	assert.Empty(t, publishedNodes())
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// deviceClassContextKey and fsTypeContextKey are the volume context keys
+// passing the device class of a volume and its filesystem to the node
+const (
+	deviceClassContextKey = "deviceClass"
+	fsTypeContextKey      = "fsType"
+)
+
+// deviceClassNameRegexp keeps device class names usable in a topology key
+var deviceClassNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,38}[a-z0-9])?$`)
+
+// reservedLvcreateArgs are set by the driver itself and cannot be passed as
+// extra arguments to lvcreate
+var reservedLvcreateArgs = map[string]bool{
+	"-n": true, "--name": true,
+	"-L": true, "--size": true,
+	"-l": true, "--extents": true,
+	"-V": true, "--virtualsize": true,
+	"-s": true, "--snapshot": true,
+	"-T": true, "--thin": true, "--thinpool": true,
+	"-y": true, "--yes": true,
+	"--addtag": true,
+}
+
+// supportedFsTypes are the filesystems the node service can format and grow
+var supportedFsTypes = map[string]bool{"ext4": true, "xfs": true}
+
+// IsSupportedFsType reports whether the node service can format and grow the filesystem
+func IsSupportedFsType(fsType string) bool {
+	return supportedFsTypes[fsType]
+}
+
+// DeviceClass maps a name selected by StorageClasses to a volume group of the
+// node, along with the defaults of the volumes provisioned in it
+type DeviceClass struct {
+	// Name is the value of the deviceClass StorageClass parameter selecting the class
+	Name string `json:"name"`
+	// VolumeGroup is the volume group volumes of the class are provisioned in
+	VolumeGroup string `json:"volumeGroup"`
+	// ThinPool provisions thin volumes from this pool of the volume group
+	// unless a StorageClass names another one
+	ThinPool string `json:"thinPool,omitempty"`
+	// OverprovisionRatio is the default overprovisionRatio of the thin pool
+	OverprovisionRatio float64 `json:"overprovisionRatio,omitempty"`
+	// FsType is the filesystem created on volumes whose capability names none
+	FsType string `json:"fsType,omitempty"`
+	// LvcreateArgs are passed to lvcreate when creating volumes of the class,
+	// for instance to set a RAID level or the number of stripes
+	LvcreateArgs []string `json:"lvcreateArgs,omitempty"`
+	// SpareGap is the number of bytes of the volume group kept free, for
+	// lvm metadata, snapshots and administrators
+	SpareGap uint64 `json:"spareGap,omitempty"`
+	// Default selects the class for StorageClasses without a deviceClass parameter
+	Default bool `json:"default,omitempty"`
+}
+
+// DeviceClasses holds the device classes of the node. The classes may be
+// replaced while the driver runs, so a class looked up once should be used
+// for the rest of a request.
+type DeviceClasses struct {
+	mtx          sync.RWMutex
+	classes      []*DeviceClass
+	defaultClass *DeviceClass
+}
+
+// NewDeviceClasses validates the device classes of the node. A single class
+// is the default class even when it is not marked as such.
+func NewDeviceClasses(classes []*DeviceClass) (*DeviceClasses, error) {
+	d := &DeviceClasses{}
+	names := map[string]bool{}
+
+	for _, class := range classes {
+		if err := class.validate(); err != nil {
+			return nil, err
+		}
+
+		if names[class.Name] {
+			return nil, fmt.Errorf("device class %s is defined more than once", class.Name)
+		}
+		names[class.Name] = true
+
+		if class.Default {
+			if d.defaultClass != nil {
+				return nil, fmt.Errorf("device classes %s and %s are both marked as default", d.defaultClass.Name, class.Name)
+			}
+			d.defaultClass = class
+		}
+
+		d.classes = append(d.classes, class)
+	}
+
+	if len(d.classes) == 1 {
+		d.defaultClass = d.classes[0]
+	}
+
+	sort.Slice(d.classes, func(i, j int) bool { return d.classes[i].Name < d.classes[j].Name })
+
+	return d, nil
+}
+
+func (c *DeviceClass) validate() error {
+	if !deviceClassNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("device class name %q must consist of at most 40 lower case alphanumeric characters or '-', and start and end with an alphanumeric character", c.Name)
+	}
+
+	if c.VolumeGroup == "" {
+		return fmt.Errorf("device class %s has no volume group", c.Name)
+	}
+
+	if c.ThinPool != "" && !lvNameRegexp.MatchString(c.ThinPool) {
+		return fmt.Errorf("device class %s has an invalid thin pool name %q", c.Name, c.ThinPool)
+	}
+
+	if c.OverprovisionRatio != 0 && c.OverprovisionRatio < 1 {
+		return fmt.Errorf("device class %s must have an overprovision ratio of at least 1, got %g", c.Name, c.OverprovisionRatio)
+	}
+
+	if c.FsType != "" && !IsSupportedFsType(c.FsType) {
+		return fmt.Errorf("device class %s has an unsupported filesystem %q", c.Name, c.FsType)
+	}
+
+	for _, arg := range c.LvcreateArgs {
+		flag, _, _ := strings.Cut(arg, "=")
+		if reservedLvcreateArgs[flag] {
+			return fmt.Errorf("device class %s cannot pass %s to lvcreate, it is set by the driver", c.Name, flag)
+		}
+	}
+
+	return nil
+}
+
+// Replace swaps the device classes for those of next, which must no longer
+// be used by the caller
+func (d *DeviceClasses) Replace(next *DeviceClasses) {
+	next.mtx.RLock()
+	defer next.mtx.RUnlock()
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.classes = next.classes
+	d.defaultClass = next.defaultClass
+}
+
+// Get returns the named device class, or the default class for an empty
+// name. It returns nil when there is no such class.
+func (d *DeviceClasses) Get(name string) *DeviceClass {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	if name == "" {
+		return d.defaultClass
+	}
+
+	for _, class := range d.classes {
+		if class.Name == name {
+			return class
+		}
+	}
+
+	return nil
+}
+
+// List returns the device classes sorted by name
+func (d *DeviceClasses) List() []*DeviceClass {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	return append([]*DeviceClass(nil), d.classes...)
+}
+
+// VolumeGroups returns the volume groups of the device classes, sorted by name
+func (d *DeviceClasses) VolumeGroups() []string {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	var vgs []string
+	seen := map[string]bool{}
+	for _, class := range d.classes {
+		if !seen[class.VolumeGroup] {
+			seen[class.VolumeGroup] = true
+			vgs = append(vgs, class.VolumeGroup)
+		}
+	}
+	sort.Strings(vgs)
+
+	return vgs
+}
+
+// HasVolumeGroup reports whether a device class provisions volumes in the volume group
+func (d *DeviceClasses) HasVolumeGroup(vg string) bool {
+	return d.ForVolumeGroup(vg) != nil
+}
+
+// ForVolumeGroup returns the default class if it uses the volume group, or
+// else the first class by name that does
+func (d *DeviceClasses) ForVolumeGroup(vg string) *DeviceClass {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	if d.defaultClass != nil && d.defaultClass.VolumeGroup == vg {
+		return d.defaultClass
+	}
+
+	for _, class := range d.classes {
+		if class.VolumeGroup == vg {
+			return class
+		}
+	}
+
+	return nil
+}
+
+// parameters returns the StorageClass parameters of a request with the
+// defaults of the device class filled in
+func (c *DeviceClass) parameters(params map[string]string) map[string]string {
+	merged := map[string]string{}
+	if c.ThinPool != "" {
+		merged[thinPoolParam] = c.ThinPool
+	}
+	if c.OverprovisionRatio != 0 {
+		merged[overprovisionRatioParam] = fmt.Sprint(c.OverprovisionRatio)
+	}
+
+	for key, value := range params {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// deviceClassTopologyKey is the segment key of the nodes a device class exists on
+func deviceClassTopologyKey(driverName string, class string) string {
+	return fmt.Sprintf("topology.%s/deviceclass-%s", driverName, class)
+}
+
+// nodeTopology returns the topology of the node, with one segment for the
+// node itself and one for each device class available on it
+func nodeTopology(driverName string, nodeId string, classes *DeviceClasses) *csi.Topology {
+	segments := map[string]string{
+		topologyKey(driverName): nodeId,
+	}
+
+	for _, class := range classes.List() {
+		segments[deviceClassTopologyKey(driverName, class.Name)] = "true"
+	}
+
+	return &csi.Topology{Segments: segments}
+}
+
+// volumeTopology returns the topology a volume of the device class is accessible from
+func volumeTopology(driverName string, nodeId string, class *DeviceClass) *csi.Topology {
+	return &csi.Topology{
+		Segments: map[string]string{
+			topologyKey(driverName):                        nodeId,
+			deviceClassTopologyKey(driverName, class.Name): "true",
+		},
+	}
+}
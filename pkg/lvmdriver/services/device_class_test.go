@@ -0,0 +1,250 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewDeviceClasses(t *testing.T) {
+	tests := []struct {
+		desc            string
+		classes         []*services.DeviceClass
+		expectedDefault string
+		expectErr       bool
+	}{
+		{
+			desc:            "single class is the default",
+			classes:         []*services.DeviceClass{{Name: "fast", VolumeGroup: "nvme"}},
+			expectedDefault: "fast",
+		},
+		{
+			desc: "marked default",
+			classes: []*services.DeviceClass{
+				{Name: "fast", VolumeGroup: "nvme"},
+				{Name: "bulk", VolumeGroup: "hdd", Default: true},
+			},
+			expectedDefault: "bulk",
+		},
+		{
+			desc: "no default",
+			classes: []*services.DeviceClass{
+				{Name: "fast", VolumeGroup: "nvme"},
+				{Name: "bulk", VolumeGroup: "hdd"},
+			},
+		},
+		{
+			desc: "classes sharing a volume group",
+			classes: []*services.DeviceClass{
+				{Name: "thick", VolumeGroup: "vg0", Default: true},
+				{Name: "thin", VolumeGroup: "vg0", ThinPool: "pool0", OverprovisionRatio: 10},
+			},
+			expectedDefault: "thick",
+		},
+		{
+			desc: "two defaults",
+			classes: []*services.DeviceClass{
+				{Name: "fast", VolumeGroup: "nvme", Default: true},
+				{Name: "bulk", VolumeGroup: "hdd", Default: true},
+			},
+			expectErr: true,
+		},
+		{
+			desc: "duplicate name",
+			classes: []*services.DeviceClass{
+				{Name: "fast", VolumeGroup: "nvme"},
+				{Name: "fast", VolumeGroup: "hdd"},
+			},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid name",
+			classes:   []*services.DeviceClass{{Name: "Fast_NVMe", VolumeGroup: "nvme"}},
+			expectErr: true,
+		},
+		{
+			desc:      "missing volume group",
+			classes:   []*services.DeviceClass{{Name: "fast"}},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid overprovision ratio",
+			classes:   []*services.DeviceClass{{Name: "thin", VolumeGroup: "vg0", ThinPool: "pool0", OverprovisionRatio: 0.5}},
+			expectErr: true,
+		},
+		{
+			desc:      "unsupported filesystem",
+			classes:   []*services.DeviceClass{{Name: "fast", VolumeGroup: "nvme", FsType: "btrfs"}},
+			expectErr: true,
+		},
+		{
+			desc:      "reserved lvcreate argument",
+			classes:   []*services.DeviceClass{{Name: "fast", VolumeGroup: "nvme", LvcreateArgs: []string{"--size=1G"}}},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			deviceClasses, err := services.NewDeviceClasses(test.classes)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			if test.expectedDefault == "" {
+				assert.Nil(t, deviceClasses.Get(""))
+			} else {
+				assert.Equal(t, test.expectedDefault, deviceClasses.Get("").Name)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeDeviceClasses(t *testing.T) {
+	tests := []struct {
+		desc             string
+		params           map[string]string
+		requiredBytes    int64
+		expectedVG       string
+		expectedPool     string
+		expectedContext  map[string]string
+		expectedTopology string
+		expectedCode     codes.Code
+	}{
+		{
+			desc:             "default class",
+			requiredBytes:    8 * mib,
+			expectedVG:       "nvme",
+			expectedContext:  map[string]string{"deviceClass": "fast", "fsType": "xfs"},
+			expectedTopology: "topology.DeviceClassSvc/deviceclass-fast",
+		},
+		{
+			desc:             "selected class",
+			params:           map[string]string{"deviceClass": "bulk"},
+			requiredBytes:    8 * mib,
+			expectedVG:       "hdd",
+			expectedContext:  map[string]string{"deviceClass": "bulk"},
+			expectedTopology: "topology.DeviceClassSvc/deviceclass-bulk",
+		},
+		{
+			desc:             "thin pool of the class",
+			params:           map[string]string{"deviceClass": "thin"},
+			requiredBytes:    2 * vgSize,
+			expectedVG:       "hdd",
+			expectedPool:     "pool0",
+			expectedContext:  map[string]string{"deviceClass": "thin"},
+			expectedTopology: "topology.DeviceClassSvc/deviceclass-thin",
+		},
+		{
+			desc:          "spare gap left free",
+			requiredBytes: vgSize - 64*mib,
+			expectedCode:  codes.ResourceExhausted,
+		},
+		{
+			desc:          "unknown class",
+			params:        map[string]string{"deviceClass": "archive"},
+			requiredBytes: 8 * mib,
+			expectedCode:  codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := lvm.NewFake()
+			fakeLvm.AddVolumeGroup("nvme", vgSize, extentSize)
+			fakeLvm.AddVolumeGroup("hdd", vgSize, extentSize)
+			assert.NoError(t, fakeLvm.AddThinPool("hdd", "pool0", 512*mib))
+
+			deviceClasses := newDeviceClasses(t,
+				&services.DeviceClass{Name: "fast", VolumeGroup: "nvme", FsType: "xfs", SpareGap: 128 * mib, Default: true},
+				&services.DeviceClass{Name: "bulk", VolumeGroup: "hdd"},
+				&services.DeviceClass{Name: "thin", VolumeGroup: "hdd", ThinPool: "pool0", OverprovisionRatio: 10},
+			)
+			controllerSvc := services.NewControllerService("DeviceClassSvc", "node_001", deviceClasses, fakeLvm, nil, nil)
+
+			resp, err := controllerSvc.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: test.requiredBytes},
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("")},
+				Parameters:         test.params,
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.Equal(t, test.expectedVG+"/pvc-1", resp.Volume.VolumeId)
+			assert.Equal(t, test.expectedContext, resp.Volume.VolumeContext)
+			assert.Equal(t, "true", resp.Volume.AccessibleTopology[0].Segments[test.expectedTopology])
+
+			lv, err := fakeLvm.GetLogicalVolume(context.Background(), test.expectedVG, "pvc-1")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPool, lv.Pool)
+		})
+	}
+}
+
+func TestGetCapacityDeviceClasses(t *testing.T) {
+	fakeLvm := lvm.NewFake()
+	fakeLvm.AddVolumeGroup("nvme", vgSize, extentSize)
+	fakeLvm.AddVolumeGroup("hdd", vgSize, extentSize)
+	assert.NoError(t, fakeLvm.AddThinPool("hdd", "pool0", 512*mib))
+
+	deviceClasses := newDeviceClasses(t,
+		&services.DeviceClass{Name: "fast", VolumeGroup: "nvme", SpareGap: 128 * mib, Default: true},
+		&services.DeviceClass{Name: "thin", VolumeGroup: "hdd", ThinPool: "pool0", OverprovisionRatio: 10},
+	)
+	controllerSvc := services.NewControllerService("DeviceClassSvc", "node_001", deviceClasses, fakeLvm, nil, nil)
+
+	tests := []struct {
+		desc             string
+		params           map[string]string
+		expectedCapacity int64
+	}{
+		{
+			desc:             "spare gap",
+			expectedCapacity: vgSize - 128*mib,
+		},
+		{
+			desc:             "thin pool of the class",
+			params:           map[string]string{"deviceClass": "thin"},
+			expectedCapacity: 5120 * mib,
+		},
+		{
+			desc:             "overridden overprovision ratio",
+			params:           map[string]string{"deviceClass": "thin", "overprovisionRatio": "2"},
+			expectedCapacity: 1024 * mib,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := controllerSvc.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: test.params})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedCapacity, resp.AvailableCapacity)
+		})
+	}
+}
+
+func TestDeleteVolumeOutsideDeviceClasses(t *testing.T) {
+	fakeLvm := lvm.NewFake()
+	fakeLvm.AddVolumeGroup("other", vgSize, extentSize)
+	assert.NoError(t, fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{VG: "other", Name: "pvc-1", Size: 8 * mib}))
+	controllerSvc := services.NewControllerService("DeviceClassSvc", "node_001", newDeviceClasses(t), fakeLvm, nil, nil)
+
+	_, err := controllerSvc.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "other/pvc-1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = fakeLvm.GetLogicalVolume(context.Background(), "other", "pvc-1")
+	assert.NoError(t, err, "the volume should not be removed")
+}
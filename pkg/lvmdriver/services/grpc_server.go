@@ -1,6 +1,7 @@
 package services
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"sync"
@@ -12,92 +13,154 @@ import (
 )
 
 type GrpcServer interface {
-	// start the service
-	Start()
-	// Graceful shutdown
+	// start the service, blocking until it is stopped. It returns nil once
+	// stopped, or the error that kept it from serving.
+	Start() error
+	// Ready is closed once the service listens for connections
+	Ready() <-chan struct{}
+	// Graceful shutdown, waiting for the RPCs in flight to complete
 	Stop()
-	// Forced shutdown
+	// Forced shutdown, cancelling the RPCs in flight
 	ForceStop()
 }
 
 type GrpcServerConfig struct {
-	Endpoint   string
-	IdServer   csi.IdentityServer
-	NodeServer csi.NodeServer
+	Endpoint         string
+	IdServer         csi.IdentityServer
+	NodeServer       csi.NodeServer
+	ControllerServer csi.ControllerServer
+	// Interceptors run around every RPC after the logging one, in order
+	Interceptors []grpc.UnaryServerInterceptor
 }
 
 // GrpcServer is the primary server for all k8s related communications
 type grpcServer struct {
-	wg         sync.WaitGroup
-	server     *grpc.Server
-	endpoint   string
-	idServer   csi.IdentityServer
-	nodeServer csi.NodeServer
+	mtx sync.Mutex // Guards server, socket and stopped
+	// server is nil until Start creates it
+	server *grpc.Server
+	// socket is the unix socket file to remove on shutdown, if any
+	socket string
+	// stopped keeps Start from serving once shutdown has begun
+	stopped          bool
+	ready            chan struct{}
+	endpoint         string
+	idServer         csi.IdentityServer
+	nodeServer       csi.NodeServer
+	controllerServer csi.ControllerServer
+	interceptors     []grpc.UnaryServerInterceptor
 }
 
 func NewGrpcServer(config GrpcServerConfig) GrpcServer {
 	return &grpcServer{
-		endpoint:   config.Endpoint,
-		idServer:   config.IdServer,
-		nodeServer: config.NodeServer,
+		ready:            make(chan struct{}),
+		endpoint:         config.Endpoint,
+		idServer:         config.IdServer,
+		nodeServer:       config.NodeServer,
+		controllerServer: config.ControllerServer,
+		interceptors:     config.Interceptors,
 	}
 }
 
-func (s *grpcServer) Start() {
-	s.wg.Add(1)
-	go s.serve()
-
-	s.wg.Wait()
-}
-
-func (s *grpcServer) Stop() {
-	if s.server != nil {
-		s.server.GracefulStop()
-	}
-}
-
-func (s *grpcServer) ForceStop() {
-	if s.server != nil {
-		s.server.Stop()
-	}
-}
-
-func (s *grpcServer) serve() {
+func (s *grpcServer) Start() error {
 	proto, addr, err := utils.ParseEndpoint(s.endpoint)
 	if err != nil {
-		klog.Fatal(err.Error())
+		return err
 	}
 
 	// Handle grpc connections over unix sockets
 	if proto == "unix" {
 		addr = "/" + addr
 		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
+			return fmt.Errorf("failed to remove %s: %v", addr, err)
 		}
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
-		klog.Fatalf("Failed to listen: %v", err)
+		return fmt.Errorf("failed to listen: %v", err)
 	}
 
+	interceptors := append([]grpc.UnaryServerInterceptor{utils.GRPCLogger}, s.interceptors...)
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(utils.GRPCLogger),
+		grpc.ChainUnaryInterceptor(interceptors...),
 	}
-	s.server = grpc.NewServer(opts...)
+	server := grpc.NewServer(opts...)
 
 	if s.idServer != nil {
-		csi.RegisterIdentityServer(s.server, s.idServer)
+		csi.RegisterIdentityServer(server, s.idServer)
 	}
 
 	if s.nodeServer != nil {
-		csi.RegisterNodeServer(s.server, s.nodeServer)
+		csi.RegisterNodeServer(server, s.nodeServer)
+	}
+
+	if s.controllerServer != nil {
+		csi.RegisterControllerServer(server, s.controllerServer)
 	}
 
+	s.mtx.Lock()
+	if proto == "unix" {
+		s.socket = addr
+	}
+	if s.stopped {
+		s.mtx.Unlock()
+		listener.Close()
+		s.removeSocket()
+		return nil
+	}
+	s.server = server
+	s.mtx.Unlock()
+
 	klog.Infof("Listening for connections on address: %#v", listener.Addr())
+	close(s.ready)
 
-	err = s.server.Serve(listener)
-	if err != nil {
-		klog.Fatalf("Failed to serve grpc server: %v", err)
+	// Serve returns nil once the server is stopped
+	if err := server.Serve(listener); err != nil {
+		return fmt.Errorf("failed to serve grpc server: %v", err)
+	}
+
+	return nil
+}
+
+func (s *grpcServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *grpcServer) Stop() {
+	s.mtx.Lock()
+	server := s.server
+	s.stopped = true
+	s.mtx.Unlock()
+
+	if server != nil {
+		server.GracefulStop()
+	}
+	s.removeSocket()
+}
+
+func (s *grpcServer) ForceStop() {
+	s.mtx.Lock()
+	server := s.server
+	s.stopped = true
+	s.mtx.Unlock()
+
+	if server != nil {
+		server.Stop()
+	}
+	s.removeSocket()
+}
+
+// removeSocket removes the unix socket file so a stale socket is not left
+// behind for the node-driver-registrar to connect to
+func (s *grpcServer) removeSocket() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.socket == "" {
+		return
+	}
+
+	if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("Failed to remove %s, error: %s", s.socket, err.Error())
 	}
 }
@@ -0,0 +1,120 @@
+package services_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// startGrpcServer starts server and waits until it listens, returning the
+// channel Start returns on
+func startGrpcServer(t *testing.T, server services.GrpcServer) <-chan error {
+	started := make(chan error, 1)
+	go func() {
+		started <- server.Start()
+	}()
+
+	select {
+	case <-server.Ready():
+	case err := <-started:
+		t.Fatalf("server stopped before listening: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not listen within 10s")
+	}
+
+	return started
+}
+
+func TestGrpcServer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+
+	server := services.NewGrpcServer(services.GrpcServerConfig{
+		Endpoint:   "unix://" + socket,
+		IdServer:   services.NewIdentityService("foo", "v1", readyFunc),
+		NodeServer: services.NewNodeService("foo", "bar", newDeviceClasses(t), nil, nil),
+	})
+	started := startGrpcServer(t, server)
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	info, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", info.GetName())
+
+	nodeInfo, err := csi.NewNodeClient(conn).NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", nodeInfo.GetNodeId())
+
+	// The controller service is not registered on the node
+	_, err = csi.NewControllerClient(conn).ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	assert.Error(t, err)
+
+	server.Stop()
+	assert.NoError(t, <-started)
+
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "socket %s is left behind", socket)
+}
+
+func TestGrpcServerStartErrors(t *testing.T) {
+	tests := []struct {
+		desc     string
+		endpoint string
+	}{
+		{
+			desc:     "invalid endpoint",
+			endpoint: "csi.sock",
+		},
+		{
+			desc:     "missing socket directory",
+			endpoint: "unix://" + filepath.Join(t.TempDir(), "missing", "csi.sock"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			server := services.NewGrpcServer(services.GrpcServerConfig{
+				Endpoint: test.endpoint,
+				IdServer: services.NewIdentityService("foo", "v1", readyFunc),
+			})
+
+			assert.Error(t, server.Start())
+
+			select {
+			case <-server.Ready():
+				t.Error("server is ready without listening")
+			default:
+			}
+		})
+	}
+}
+
+func TestGrpcServerStopBeforeStart(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+
+	server := services.NewGrpcServer(services.GrpcServerConfig{
+		Endpoint: "unix://" + socket,
+		IdServer: services.NewIdentityService("foo", "v1", readyFunc),
+	})
+
+	server.Stop()
+	assert.NoError(t, server.Start())
+
+	_, err := os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "socket %s is left behind", socket)
+}
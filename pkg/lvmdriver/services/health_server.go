@@ -0,0 +1,111 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultHealthAddress is the address the sample DaemonSet probes
+const DefaultHealthAddress = ":29653"
+
+// healthReadTimeout bounds reading the requests of the probes
+const healthReadTimeout = 10 * time.Second
+
+// healthReport is the JSON body of the health endpoints
+type healthReport struct {
+	// Status is "ok" if every check passed, "failed" otherwise
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// HealthServer serves the status checks over HTTP for the probes of the
+// kubelet. /healthz runs the liveness checks, that the lvm tools are present,
+// the volume groups reachable and the endpoint listening, and /readyz all of
+// them. Both answer 503 Service Unavailable with the failed checks when one
+// fails.
+type HealthServer struct {
+	address       string
+	statusService *StatusService
+	server        *http.Server
+	ready         chan struct{}
+}
+
+func NewHealthServer(address string, statusService *StatusService) *HealthServer {
+	s := &HealthServer{
+		address:       address,
+		statusService: statusService,
+		ready:         make(chan struct{}),
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: healthReadTimeout,
+	}
+
+	return s
+}
+
+// Handler returns the handler of the health endpoints
+func (s *HealthServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleCheck(true))
+	mux.HandleFunc("/readyz", s.handleCheck(false))
+	return mux
+}
+
+// Start serves the health endpoints, blocking until the server is stopped.
+// It returns nil once stopped, or the error that kept it from serving.
+func (s *HealthServer) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.address, err)
+	}
+
+	klog.Infof("Serving health checks on address: %s", listener.Addr())
+	close(s.ready)
+
+	// A server stopped before serving returns ErrServerClosed at once
+	if err := s.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve health checks: %v", err)
+	}
+
+	return nil
+}
+
+// Ready is closed once the server listens for connections
+func (s *HealthServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Stop closes the server, probes are quick enough not to wait for
+func (s *HealthServer) Stop() {
+	if err := s.server.Close(); err != nil {
+		klog.Errorf("Failed to stop the health server: %v", err)
+	}
+}
+
+func (s *HealthServer) handleCheck(liveness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Status: "ok"}
+		code := http.StatusOK
+
+		var err error
+		report.Checks, err = s.statusService.Check(liveness)
+		if err != nil {
+			klog.V(2).Infof("health check %s failed: %v", r.URL.Path, err)
+			report.Status = "failed"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			klog.Errorf("Failed to write the health report: %v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package services_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthServerHandler(t *testing.T) {
+	statusSvc := services.NewStatusService(0,
+		staticCheck("endpoint", true, nil),
+		staticCheck("volumeGroups", true, fmt.Errorf("volume group vg0 is missing")),
+		staticCheck("thinPools", false, fmt.Errorf("thin pool vg0/pool0 is full")),
+	)
+	handler := services.NewHealthServer("", statusSvc).Handler()
+
+	tests := []struct {
+		path           string
+		expectedCode   int
+		expectedReport string
+	}{
+		{
+			path:           "/healthz",
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedReport: `{"status":"failed","checks":[{"name":"endpoint"},{"name":"volumeGroups","error":"volume group vg0 is missing"}]}`,
+		},
+		{
+			path:         "/readyz",
+			expectedCode: http.StatusServiceUnavailable,
+			expectedReport: `{"status":"failed","checks":[{"name":"endpoint"},{"name":"volumeGroups","error":"volume group vg0 is missing"},` +
+				`{"name":"thinPools","error":"thin pool vg0/pool0 is full"}]}`,
+		},
+		{
+			path:         "/metrics",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			if test.expectedReport != "" {
+				assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+				assert.JSONEq(t, test.expectedReport, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestHealthServerStop(t *testing.T) {
+	server := services.NewHealthServer("127.0.0.1:0", services.NewStatusService(0))
+
+	started := make(chan error, 1)
+	go func() {
+		started <- server.Start()
+	}()
+
+	select {
+	case <-server.Ready():
+	case err := <-started:
+		t.Fatalf("server stopped before listening: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not listen within 10s")
+	}
+
+	server.Stop()
+	assert.NoError(t, <-started)
+}
+
+func TestHealthServerStopBeforeStart(t *testing.T) {
+	server := services.NewHealthServer("127.0.0.1:0", services.NewStatusService(0))
+
+	server.Stop()
+	assert.NoError(t, server.Start())
+}
+
+// A report without checks lists none rather than null
+func TestHealthServerEmptyReport(t *testing.T) {
+	handler := services.NewHealthServer("", services.NewStatusService(0)).Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report map[string]interface{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, "ok", report["status"])
+	assert.Equal(t, []interface{}{}, report["checks"])
+}
@@ -7,7 +7,6 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/wrapperspb"
-	"k8s.io/klog/v2"
 )
 
 // IdentityService handles requests from the container orchestrator
@@ -16,7 +15,7 @@ type IdentityService struct {
 	csi.UnimplementedIdentityServer
 	name         string
 	version      string
-	capabilities []csi.PluginCapability_Service_Type
+	capabilities []*csi.PluginCapability
 	ready        func() (bool, error)
 }
 
@@ -26,19 +25,47 @@ type IdentityService struct {
 // It should return non-nil error if the plugin is not healthy.
 // If the plugin is not yet ready, it should return (false, nil).
 // Otherwise, return (true, nil).
-func NewIdentityService(name string, version string, ready func() (bool, error)) csi.IdentityServer {
+//
+// capabilities are the plugin capabilities advertised to the orchestrator,
+// the UNKNOWN service is advertised when none are given.
+func NewIdentityService(name string, version string, ready func() (bool, error), capabilities ...*csi.PluginCapability) csi.IdentityServer {
+	if len(capabilities) == 0 {
+		capabilities = []*csi.PluginCapability{
+			ServiceCapability(csi.PluginCapability_Service_UNKNOWN),
+		}
+	}
+
 	return &IdentityService{
-		ready:   ready,
-		name:    name,
-		version: version,
-		capabilities: []csi.PluginCapability_Service_Type{
-			csi.PluginCapability_Service_UNKNOWN,
+		ready:        ready,
+		name:         name,
+		version:      version,
+		capabilities: capabilities,
+	}
+}
+
+// ServiceCapability returns the plugin capability advertising a service
+func ServiceCapability(service csi.PluginCapability_Service_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: service,
+			},
+		},
+	}
+}
+
+// VolumeExpansionCapability returns the plugin capability advertising how volumes can be expanded
+func VolumeExpansionCapability(expansion csi.PluginCapability_VolumeExpansion_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_VolumeExpansion_{
+			VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+				Type: expansion,
+			},
 		},
 	}
 }
 
 func (s IdentityService) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	klog.V(2).Info("received PluginInfoRequest")
 	if s.name == "" {
 		return nil, status.Error(codes.Unavailable, "Driver name not configured")
 	}
@@ -56,22 +83,8 @@ func (s IdentityService) GetPluginInfo(ctx context.Context, req *csi.GetPluginIn
 }
 
 func (s IdentityService) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	klog.V(2).Info("received GetPluginCapabilitiesRequest")
-	capabilities := make([]*csi.PluginCapability, 0, len(s.capabilities))
-
-	for _, cap := range s.capabilities {
-		capabilities = append(capabilities, &csi.PluginCapability{
-			// This should be replaced as we add capabilities
-			Type: &csi.PluginCapability_Service_{
-				Service: &csi.PluginCapability_Service{
-					Type: cap,
-				},
-			},
-		})
-	}
-
 	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: capabilities,
+		Capabilities: s.capabilities,
 	}, nil
 }
 
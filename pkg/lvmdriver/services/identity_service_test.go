@@ -2,11 +2,14 @@ package services_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var readyFunc = func() (bool, error) {
@@ -62,6 +65,15 @@ func TestIdentityProbe(t *testing.T) {
 	assert.Equal(t, resp.Ready.Value, true)
 }
 
+func TestIdentityProbeNotReady(t *testing.T) {
+	statusSvc := services.NewStatusService(0, staticCheck("volumeGroups", false, fmt.Errorf("volume group vg0 is partial")))
+	idSvc := services.NewIdentityService("foo", "unix://bar", statusSvc.Ready)
+
+	_, err := idSvc.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "volumeGroups: volume group vg0 is partial")
+}
+
 func TestIdentityGetPluginCapabilities(t *testing.T) {
 	validCapabilities := []csi.PluginCapability_Service_Type{
 		csi.PluginCapability_Service_UNKNOWN,
@@ -85,3 +97,35 @@ func TestIdentityGetPluginCapabilities(t *testing.T) {
 	// Make sure all valid and only valid capabilities were returned
 	assert.ElementsMatch(t, returnedCapabilities, validCapabilities)
 }
+
+func TestIdentityGetPluginCapabilitiesWithController(t *testing.T) {
+	validCapabilities := []csi.PluginCapability_Service_Type{
+		csi.PluginCapability_Service_CONTROLLER_SERVICE,
+		csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+	}
+
+	idSvc := services.NewIdentityService("foo", "unix://bar", readyFunc,
+		services.ServiceCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+		services.ServiceCapability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS),
+		services.VolumeExpansionCapability(csi.PluginCapability_VolumeExpansion_ONLINE),
+	)
+	req := &csi.GetPluginCapabilitiesRequest{}
+
+	resp, err := idSvc.GetPluginCapabilities(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	returnedCapabilities := make([]csi.PluginCapability_Service_Type, 0, len(resp.Capabilities))
+	var expansion []csi.PluginCapability_VolumeExpansion_Type
+
+	for _, cap := range resp.Capabilities {
+		if cap.GetVolumeExpansion() != nil {
+			expansion = append(expansion, cap.GetVolumeExpansion().Type)
+			continue
+		}
+		returnedCapabilities = append(returnedCapabilities, cap.GetService().Type)
+	}
+
+	assert.ElementsMatch(t, returnedCapabilities, validCapabilities)
+	assert.Equal(t, []csi.PluginCapability_VolumeExpansion_Type{csi.PluginCapability_VolumeExpansion_ONLINE}, expansion)
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lvmErrorCodes maps lvm failures to the CSI status code reported for them
+var lvmErrorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{lvm.ErrInsufficientSpace, codes.ResourceExhausted},
+	{lvm.ErrNotFound, codes.NotFound},
+	{lvm.ErrAlreadyExists, codes.AlreadyExists},
+	{lvm.ErrLocked, codes.Aborted},
+}
+
+// lvmError turns a failed lvm operation into a status error, prefixing the
+// lvm output with the formatted message
+func lvmError(err error, format string, args ...interface{}) error {
+	return status.Errorf(lvmErrorCode(err), "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+func lvmErrorCode(err error) codes.Code {
+	for _, c := range lvmErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+
+	return codes.Internal
+}
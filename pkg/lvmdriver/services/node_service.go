@@ -2,50 +2,88 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/tracing"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
+	"k8s.io/mount-utils"
 )
 
+// DefaultFsType is the filesystem created on volumes when neither their
+// capability nor their device class names one
+const DefaultFsType = "ext4"
+
 type NodeService struct {
 	csi.UnimplementedNodeServer
-	mtx          sync.RWMutex // Need to handle concurrent system calls
-	capabilities []csi.NodeServiceCapability_RPC_Type
-	nodeId       string
-	topologies   *csi.Topology
+	mtx           sync.RWMutex // Need to handle concurrent system calls
+	capabilities  []csi.NodeServiceCapability_RPC_Type
+	driverName    string
+	nodeId        string
+	deviceClasses *DeviceClasses
+	mounter       *mount.SafeFormatAndMount
+	lvm           lvm.Interface
+	// defaultFsType and mountOptions apply to every filesystem staged, guarded by mtx
+	defaultFsType string
+	mountOptions  []string
+	// stagedOptions are the options filesystems were staged with, by
+	// staging path, guarded by mtx
+	stagedOptions map[string][]string
 }
 
-func NewNodeService(name string, nodeId string) csi.NodeServer {
-	topologyKey := fmt.Sprintf("topology.%s/node", name)
+// topologyKey is the segment key identifying the node a volume is accessible from
+func topologyKey(name string) string {
+	return fmt.Sprintf("topology.%s/node", name)
+}
 
+func NewNodeService(name string, nodeId string, deviceClasses *DeviceClasses, mounter *mount.SafeFormatAndMount, lvmClient lvm.Interface) *NodeService {
 	return &NodeService{
-		nodeId: nodeId,
+		driverName:    name,
+		nodeId:        nodeId,
+		deviceClasses: deviceClasses,
+		mounter:       mounter,
+		lvm:           lvmClient,
 		capabilities: []csi.NodeServiceCapability_RPC_Type{
-			csi.NodeServiceCapability_RPC_UNKNOWN,
-		},
-		topologies: &csi.Topology{
-			Segments: map[string]string{
-				topologyKey: nodeId,
-			},
+			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+			csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+			csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 		},
+		defaultFsType: DefaultFsType,
+		stagedOptions: map[string][]string{},
 	}
 }
 
-func (n *NodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	klog.V(2).Infof("received %#v", *req)
+// SetMountDefaults sets the filesystem created on volumes whose capability
+// and device class name none, and the options added to every filesystem
+// mount. Volumes already staged keep their filesystem and options.
+func (n *NodeService) SetMountDefaults(fsType string, options []string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
 
+	n.defaultFsType = fsType
+	n.mountOptions = options
+}
+
+// NodeGetInfo publishes a topology segment for the node and one for each of
+// its device classes. The kubelet only reads them when the driver registers.
+func (n *NodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	return &csi.NodeGetInfoResponse{
 		NodeId:             n.nodeId,
-		AccessibleTopology: n.topologies,
+		AccessibleTopology: nodeTopology(n.driverName, n.nodeId, n.deviceClasses),
 	}, nil
 }
 
 func (n *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(2).Infof("received %#v", req)
 	csiCapabilities := make([]*csi.NodeServiceCapability, 0, len(n.capabilities))
 
 	for _, cap := range n.capabilities {
@@ -63,18 +101,543 @@ func (n *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 	}, nil
 }
 
+func (n *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	staging := req.GetStagingTargetPath()
+	if staging == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path is missing from the request")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if err := validateVolumeCapabilities([]*csi.VolumeCapability{volCap}); err != nil {
+		return nil, err
+	}
+
+	vg, lv, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := n.activateVolume(ctx, vg, lv); err != nil {
+		return nil, err
+	}
+
+	// Block volumes are bind mounted straight from the device node on publish
+	if volCap.GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := n.stageMount(ctx, utils.DevicePath(vg, lv), staging, volCap.GetMount(), req.GetVolumeContext()[fsTypeContextKey]); err != nil {
+		return nil, err
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (n *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	staging := req.GetStagingTargetPath()
+	if staging == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path is missing from the request")
+	}
+
+	// The staging directory belongs to the CO so it is only unmounted here
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(staging)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "failed to check mount point %s: %v", staging, err)
+	}
+
+	if !notMnt {
+		klog.FromContext(ctx).V(2).Info("unmounting staging path", "path", staging)
+		if err := tracing.WithParent(ctx, n.mounter).Unmount(staging); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", staging, err)
+		}
+	}
+	delete(n.stagedOptions, filepath.Clean(staging))
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
 func (n *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(2).Infof("received NodePublishVolumeRequest: %v", req)
 	n.mtx.Lock()
 	defer n.mtx.Unlock()
 
-	return nil, status.Error(codes.Unimplemented, "NodePublishVolume is not yet implemented")
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is missing from the request")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if err := validateVolumeCapabilities([]*csi.VolumeCapability{volCap}); err != nil {
+		return nil, err
+	}
+
+	vg, lv, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	device := utils.DevicePath(vg, lv)
+
+	if volCap.GetBlock() != nil {
+		if err := n.activateVolume(ctx, vg, lv); err != nil {
+			return nil, err
+		}
+		err = n.publishBlock(ctx, device, target, req.GetReadonly())
+	} else {
+		err = n.publishMount(ctx, req.GetStagingTargetPath(), target, req.GetReadonly())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 func (n *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(2).Infof("received NodeUnpublishVolumeRequest: %v", req)
 	n.mtx.Lock()
 	defer n.mtx.Unlock()
 
-	return nil, status.Error(codes.Unimplemented, "NodeUnpublishVolume is not yet implemented")
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is missing from the request")
+	}
+
+	// CleanupMountPoint treats a missing target as already unmounted and
+	// removes both the directory of a mount and the file of a block volume
+	if err := mount.CleanupMountPoint(target, tracing.WithParent(ctx, n.mounter), true); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", target, err)
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// NodeExpandVolume grows the filesystem of a mounted volume to fill its
+// logical volume, which the controller has already extended
+func (n *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	volumePath := req.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	lv, err := n.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		return nil, lvmError(err, "failed to look up volume %s/%s", vg, name)
+	}
+
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to check volume path %s: %v", volumePath, err)
+	}
+
+	// Block volumes have no filesystem, the device grew with the logical volume
+	if req.GetVolumeCapability().GetBlock() != nil || !info.IsDir() {
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: int64(lv.Size)}, nil
+	}
+
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check mount point %s: %v", volumePath, err)
+	}
+	if notMnt {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not mounted at %s", req.GetVolumeId(), volumePath)
+	}
+
+	// resize2fs grows ext filesystems through the device, xfs_growfs through the mount point
+	device := utils.DevicePath(vg, name)
+	klog.FromContext(ctx).V(2).Info("resizing filesystem", "device", device, "path", volumePath)
+	if _, err := mount.NewResizeFs(tracing.WithParent(ctx, n.mounter).Exec).Resize(device, volumePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to resize filesystem of %s: %v", device, err)
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: int64(lv.Size)}, nil
+}
+
+// NodeGetVolumeStats reports the usage of the filesystem of a volume, or the
+// size of a block volume, along with its condition. The volume is abnormal
+// when its logical volume is missing or inactive, or its staged filesystem
+// was remounted read-only after errors.
+func (n *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is missing from the request")
+	}
+
+	volumePath := req.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is missing from the request")
+	}
+
+	vg, name, err := utils.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to check volume path %s: %v", volumePath, err)
+	}
+
+	lv, err := n.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: abnormalCondition("logical volume %s/%s does not exist", vg, name),
+			}, nil
+		}
+		return nil, lvmError(err, "failed to look up volume %s/%s", vg, name)
+	}
+
+	resp := &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: healthyCondition(),
+	}
+
+	// Block volumes are published as a file bind mounted from the device node
+	if !info.IsDir() {
+		resp.Usage = []*csi.VolumeUsage{{Unit: csi.VolumeUsage_BYTES, Total: int64(lv.Size)}}
+	} else {
+		resp.Usage, err = filesystemUsage(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get filesystem usage of %s: %v", volumePath, err)
+		}
+	}
+
+	if !lv.Active() {
+		resp.VolumeCondition = abnormalCondition("logical volume %s/%s is not active", vg, name)
+		return resp, nil
+	}
+
+	if info.IsDir() {
+		readOnly, err := n.mountedReadOnly(req.GetStagingTargetPath())
+		if err != nil {
+			return nil, err
+		}
+		if readOnly {
+			resp.VolumeCondition = abnormalCondition("filesystem staged at %s is mounted read-only, it may have been remounted after errors", req.GetStagingTargetPath())
+		}
+	}
+
+	return resp, nil
+}
+
+// activateVolume makes sure the logical volume exists and its device node is
+// present, activating it when it is not
+func (n *NodeService) activateVolume(ctx context.Context, vg string, name string) error {
+	lv, err := n.lvm.GetLogicalVolume(ctx, vg, name)
+	if err != nil {
+		if errors.Is(err, lvm.ErrNotFound) {
+			return status.Errorf(codes.NotFound, "volume %s/%s does not exist", vg, name)
+		}
+		return lvmError(err, "failed to look up volume %s/%s", vg, name)
+	}
+
+	if lv.Active() {
+		return nil
+	}
+
+	klog.FromContext(ctx).V(2).Info("activating volume", "vg", vg, "lv", name)
+	if err := n.lvm.SetLogicalVolumeActive(ctx, vg, name, true); err != nil {
+		return lvmError(err, "failed to activate volume %s/%s", vg, name)
+	}
+
+	return nil
+}
+
+// stageMount formats the device if needed and mounts it on the staging
+// directory. The filesystem of the capability takes precedence over the
+// default of the device class of the volume. The caller must hold n.mtx.
+func (n *NodeService) stageMount(ctx context.Context, device string, staging string, mnt *csi.VolumeCapability_MountVolume, classFsType string) error {
+	logger := klog.FromContext(ctx)
+	notMnt, err := n.ensureMountPoint(staging)
+	if err != nil {
+		return err
+	}
+
+	// The flags of the capability come last so they override the defaults
+	options := append(append([]string(nil), n.mountOptions...), mnt.GetMountFlags()...)
+
+	if !notMnt {
+		logger.V(2).Info("device is already staged", "device", device, "path", staging)
+		n.stagedOptions[filepath.Clean(staging)] = options
+		return nil
+	}
+
+	fsType := mnt.GetFsType()
+	if fsType == "" {
+		fsType = classFsType
+	}
+	if fsType == "" {
+		fsType = n.defaultFsType
+	}
+
+	logger.V(2).Info("mounting device", "device", device, "path", staging, "fs_type", fsType, "options", options)
+	if err := tracing.WithParent(ctx, n.mounter).FormatAndMount(device, staging, fsType, options); err != nil {
+		return status.Errorf(codes.Internal, "failed to mount %s at %s: %v", device, staging, err)
+	}
+	n.stagedOptions[filepath.Clean(staging)] = options
+
+	return nil
+}
+
+// publishMount bind mounts the staged filesystem on the target directory
+func (n *NodeService) publishMount(ctx context.Context, staging string, target string, readonly bool) error {
+	logger := klog.FromContext(ctx)
+	if staging == "" {
+		return status.Error(codes.InvalidArgument, "staging target path is missing from the request")
+	}
+
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(staging)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "failed to check mount point %s: %v", staging, err)
+	}
+	if err != nil || notMnt {
+		return status.Errorf(codes.FailedPrecondition, "volume is not staged at %s", staging)
+	}
+
+	notMnt, err = n.ensureMountPoint(target)
+	if err != nil {
+		return err
+	}
+
+	if !notMnt {
+		logger.V(2).Info("staging path is already mounted", "staging", staging, "path", target)
+		return nil
+	}
+
+	options := []string{"bind"}
+	if readonly {
+		options = append(options, "ro")
+	}
+
+	logger.V(2).Info("bind mounting staging path", "staging", staging, "path", target, "options", options)
+	if err := tracing.WithParent(ctx, n.mounter).Mount(staging, target, "", options); err != nil {
+		return status.Errorf(codes.Internal, "failed to bind mount %s at %s: %v", staging, target, err)
+	}
+
+	return nil
+}
+
+// publishBlock bind mounts the device node onto a file at the target path
+func (n *NodeService) publishBlock(ctx context.Context, device string, target string, readonly bool) error {
+	logger := klog.FromContext(ctx)
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create parent directory of %s: %v", target, err)
+	}
+
+	info, err := os.Stat(target)
+	switch {
+	case os.IsNotExist(err):
+		f, err := os.OpenFile(target, os.O_CREATE, 0660)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to create target file %s: %v", target, err)
+		}
+		f.Close()
+	case err != nil:
+		return status.Errorf(codes.Internal, "failed to check target path %s: %v", target, err)
+	case info.IsDir():
+		return status.Errorf(codes.InvalidArgument, "target path %s is a directory but block access was requested", target)
+	}
+
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(target)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check mount point %s: %v", target, err)
+	}
+
+	if !notMnt {
+		logger.V(2).Info("device is already bind mounted", "device", device, "path", target)
+		return nil
+	}
+
+	options := []string{"bind"}
+	if readonly {
+		options = append(options, "ro")
+	}
+
+	logger.V(2).Info("bind mounting block device", "device", device, "path", target, "options", options)
+	if err := tracing.WithParent(ctx, n.mounter).Mount(device, target, "", options); err != nil {
+		return status.Errorf(codes.Internal, "failed to bind mount %s at %s: %v", device, target, err)
+	}
+
+	return nil
+}
+
+// mountedReadOnly reports whether the filesystem staged at staging is mounted
+// read-only though it was staged read-write, so it was remounted by the
+// kernel, e.g. by ext4 with errors=remount-ro. Filesystems staged before the
+// driver started are taken as staged with the current mount options.
+func (n *NodeService) mountedReadOnly(staging string) (bool, error) {
+	// The staging path is optional in the request
+	if staging == "" {
+		return false, nil
+	}
+
+	// Filesystems staged read-only on purpose are left alone
+	staging = filepath.Clean(staging)
+	options, ok := n.stagedOptions[staging]
+	if !ok {
+		options = n.mountOptions
+	}
+	for _, option := range options {
+		if option == "ro" {
+			return false, nil
+		}
+	}
+
+	mountPoints, err := n.mounter.List()
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+
+	for _, mountPoint := range mountPoints {
+		if filepath.Clean(mountPoint.Path) != staging {
+			continue
+		}
+		for _, option := range mountPoint.Opts {
+			if option == "ro" {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Published reports whether the volume vg/name is mounted on the node, as a
+// staged or published filesystem or a bind mounted block device. The mount
+// table is read rather than the requests served so that volumes mounted
+// before the driver restarted are reported too.
+func (n *NodeService) Published(vg string, name string) (bool, error) {
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+
+	// The mount table names the device mapper node the device path links to
+	device := utils.DevicePath(vg, name)
+	devices := []string{device}
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		devices = append(devices, resolved)
+	}
+
+	mountPoints, err := n.mounter.List()
+	if err != nil {
+		return false, fmt.Errorf("failed to list mount points: %v", err)
+	}
+
+	for _, mountPoint := range mountPoints {
+		for _, candidate := range devices {
+			if mountPoint.Device == candidate {
+				return true, nil
+			}
+		}
+	}
+
+	// Block devices are bind mounted from the devtmpfs holding their node,
+	// which the mount table names in their place
+	refs, err := n.mounter.GetMountRefs(device)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up the mounts of %s: %v", device, err)
+	}
+
+	return len(refs) > 0, nil
+}
+
+// filesystemUsage returns the bytes and inodes used by the filesystem mounted at path
+func filesystemUsage(path string) ([]*csi.VolumeUsage, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(stat.Bsize)
+	usage := []*csi.VolumeUsage{{
+		Unit:      csi.VolumeUsage_BYTES,
+		Total:     int64(stat.Blocks) * blockSize,
+		Available: int64(stat.Bavail) * blockSize,
+		Used:      int64(stat.Blocks-stat.Bfree) * blockSize,
+	}}
+
+	// Filesystems allocating inodes dynamically may report none
+	if stat.Files > 0 {
+		usage = append(usage, &csi.VolumeUsage{
+			Unit:      csi.VolumeUsage_INODES,
+			Total:     int64(stat.Files),
+			Available: int64(stat.Ffree),
+			Used:      int64(stat.Files - stat.Ffree),
+		})
+	}
+
+	return usage, nil
+}
+
+// healthyCondition returns the condition of a volume in a normal state
+func healthyCondition() *csi.VolumeCondition {
+	return &csi.VolumeCondition{Message: "volume is healthy"}
+}
+
+// abnormalCondition returns the condition of a volume in an abnormal state
+func abnormalCondition(format string, args ...interface{}) *csi.VolumeCondition {
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// ensureMountPoint creates the directory at path if it does not exist and
+// reports whether it is not yet a mount point
+func (n *NodeService) ensureMountPoint(path string) (bool, error) {
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(path)
+	if err == nil {
+		return notMnt, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return false, status.Errorf(codes.Internal, "failed to check mount point %s: %v", path, err)
+	}
+
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return false, status.Errorf(codes.Internal, "failed to create directory %s: %v", path, err)
+	}
+
+	return true, nil
 }
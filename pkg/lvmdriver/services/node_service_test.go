@@ -3,36 +3,81 @@ package services_test
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
 	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
 )
 
+// newFakeMounter returns a mounter that records mounts in memory and treats
+// every device as unformatted
+func newFakeMounter() (*mount.FakeMounter, *mount.SafeFormatAndMount) {
+	fakeMounter := mount.NewFakeMounter(nil)
+	return fakeMounter, mount.NewSafeFormatAndMount(fakeMounter, &testingexec.FakeExec{DisableScripts: true})
+}
+
+// newFakeLvm returns an lvm backend holding the active volume vg0/lv0
+func newFakeLvm() *lvm.Fake {
+	fakeLvm := newFakeVolumeGroup()
+	_ = fakeLvm.CreateLogicalVolume(context.Background(), lvm.CreateOptions{VG: "vg0", Name: "lv0", Size: 8 * mib})
+	return fakeLvm
+}
+
+func mountCapability(fsType string, flags ...string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				FsType:     fsType,
+				MountFlags: flags,
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+}
+
 func TestNodeGetInfo(t *testing.T) {
 	driverName := "foo"
 	nodeName := "bar"
 	topologyKey := fmt.Sprintf("topology.%s/node", driverName)
 
-	nodeSvc := services.NewNodeService(driverName, nodeName)
+	deviceClasses := newDeviceClasses(t,
+		&services.DeviceClass{Name: "fast", VolumeGroup: "nvme", Default: true},
+		&services.DeviceClass{Name: "bulk", VolumeGroup: "hdd"},
+	)
+	nodeSvc := services.NewNodeService(driverName, nodeName, deviceClasses, nil, nil)
 	req := &csi.NodeGetInfoRequest{}
 
 	resp, err := nodeSvc.NodeGetInfo(context.Background(), req)
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.Equal(t, resp.NodeId, nodeName)
-	assert.Equal(t, len(resp.AccessibleTopology.Segments), 1)
-	assert.Contains(t, resp.AccessibleTopology.Segments, topologyKey)
-	assert.Equal(t, resp.AccessibleTopology.Segments[topologyKey], nodeName)
+	assert.Equal(t, map[string]string{
+		topologyKey:                     nodeName,
+		"topology.foo/deviceclass-bulk": "true",
+		"topology.foo/deviceclass-fast": "true",
+	}, resp.AccessibleTopology.Segments)
 }
 
 func TestNodeGetCapabilites(t *testing.T) {
 	validCapabilities := []csi.NodeServiceCapability_RPC_Type{
-		csi.NodeServiceCapability_RPC_UNKNOWN,
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 
-	nodeSvc := services.NewNodeService("NodeGetCapabilitiesSvc", "node_001")
+	nodeSvc := services.NewNodeService("NodeGetCapabilitiesSvc", "node_001", newDeviceClasses(t), nil, nil)
 	req := &csi.NodeGetCapabilitiesRequest{}
 
 	resp, err := nodeSvc.NodeGetCapabilities(context.Background(), req)
@@ -51,20 +96,834 @@ func TestNodeGetCapabilites(t *testing.T) {
 	assert.ElementsMatch(t, returnedCapabilities, validCapabilities)
 }
 
+// stageVolume stages vg0/lv0 with a mount capability and returns the staging path
+func stageVolume(t *testing.T, nodeSvc csi.NodeServer, volCap *csi.VolumeCapability) string {
+	staging := filepath.Join(t.TempDir(), "staging")
+
+	_, err := nodeSvc.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "vg0/lv0",
+		StagingTargetPath: staging,
+		VolumeCapability:  volCap,
+	})
+	assert.NoError(t, err)
+
+	return staging
+}
+
+func TestNodeStageVolume(t *testing.T) {
+	tests := []struct {
+		desc         string
+		req          *csi.NodeStageVolumeRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc: "missing volume id",
+			req: &csi.NodeStageVolumeRequest{
+				StagingTargetPath: "staging",
+				VolumeCapability:  mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing staging path",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:         "vg0/lv0",
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing volume capability",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "vg0/lv0",
+				StagingTargetPath: "staging",
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "malformed volume id",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "lv0",
+				StagingTargetPath: "staging",
+				VolumeCapability:  mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "successful stage",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "vg0/lv0",
+				StagingTargetPath: "staging",
+				VolumeCapability:  mountCapability("xfs", "noatime"),
+			},
+			expectedCode: codes.OK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+			// Keep the mounts inside the test's temporary directory
+			if test.req.StagingTargetPath != "" {
+				test.req.StagingTargetPath = filepath.Join(t.TempDir(), test.req.StagingTargetPath)
+			}
+
+			resp, err := nodeSvc.NodeStageVolume(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				return
+			}
+
+			assert.NotNil(t, resp)
+			assert.DirExists(t, test.req.StagingTargetPath)
+			assert.Len(t, fakeMounter.MountPoints, 1)
+			assert.Equal(t, "/dev/vg0/lv0", fakeMounter.MountPoints[0].Device)
+			assert.Equal(t, test.req.StagingTargetPath, fakeMounter.MountPoints[0].Path)
+			assert.Equal(t, "xfs", fakeMounter.MountPoints[0].Type)
+			assert.Contains(t, fakeMounter.MountPoints[0].Opts, "noatime")
+		})
+	}
+}
+
+func TestNodeStageVolumeDeviceClassFsType(t *testing.T) {
+	tests := []struct {
+		desc           string
+		volCap         *csi.VolumeCapability
+		volumeContext  map[string]string
+		expectedFsType string
+	}{
+		{
+			desc:           "default filesystem",
+			volCap:         mountCapability(""),
+			expectedFsType: "ext4",
+		},
+		{
+			desc:           "device class filesystem",
+			volCap:         mountCapability(""),
+			volumeContext:  map[string]string{"deviceClass": "fast", "fsType": "xfs"},
+			expectedFsType: "xfs",
+		},
+		{
+			desc:           "capability overrides device class",
+			volCap:         mountCapability("ext4"),
+			volumeContext:  map[string]string{"deviceClass": "fast", "fsType": "xfs"},
+			expectedFsType: "ext4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+			_, err := nodeSvc.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "vg0/lv0",
+				StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+				VolumeCapability:  test.volCap,
+				VolumeContext:     test.volumeContext,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, fakeMounter.MountPoints, 1)
+			assert.Equal(t, test.expectedFsType, fakeMounter.MountPoints[0].Type)
+		})
+	}
+}
+
+func TestNodeStageVolumeMountDefaults(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+	nodeSvc.SetMountDefaults("xfs", []string{"noatime"})
+
+	stageVolume(t, nodeSvc, mountCapability("", "discard"))
+	assert.Len(t, fakeMounter.MountPoints, 1)
+	assert.Equal(t, "xfs", fakeMounter.MountPoints[0].Type)
+	assert.Contains(t, fakeMounter.MountPoints[0].Opts, "noatime")
+	assert.Contains(t, fakeMounter.MountPoints[0].Opts, "discard")
+}
+
+func TestNodeGetInfoReplacedDeviceClasses(t *testing.T) {
+	deviceClasses := newDeviceClasses(t)
+	nodeSvc := services.NewNodeService("foo", "bar", deviceClasses, nil, nil)
+
+	deviceClasses.Replace(newDeviceClasses(t,
+		&services.DeviceClass{Name: "default", VolumeGroup: "vg0", Default: true},
+		&services.DeviceClass{Name: "bulk", VolumeGroup: "hdd"},
+	))
+
+	resp, err := nodeSvc.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "true", resp.AccessibleTopology.Segments["topology.foo/deviceclass-bulk"])
+	assert.Equal(t, "true", resp.AccessibleTopology.Segments["topology.foo/deviceclass-default"])
+}
+
+func TestNodeStageVolumeExistingFilesystem(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+	// blkid reports an existing filesystem and fsck finds nothing to repair
+	fakeCmd := func(output string) testingexec.FakeCommandAction {
+		return func(cmd string, args ...string) exec.Cmd {
+			return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{
+					func() ([]byte, []byte, error) { return []byte(output), nil, nil },
+				},
+			}, cmd, args...)
+		}
+	}
+	fakeExec := &testingexec.FakeExec{
+		CommandScript: []testingexec.FakeCommandAction{
+			fakeCmd("TYPE=ext4\n"),
+			fakeCmd(""),
+		},
+	}
+	mounter.Exec = fakeExec
+
+	stageVolume(t, nodeSvc, mountCapability(""))
+	assert.Equal(t, 2, fakeExec.CommandCalls, "only blkid and fsck should have run")
+	assert.Len(t, fakeMounter.MountPoints, 1)
+	assert.Equal(t, "ext4", fakeMounter.MountPoints[0].Type)
+}
+
+func TestNodeStageVolumeIdempotent(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "vg0/lv0",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability:  mountCapability("ext4"),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := nodeSvc.NodeStageVolume(context.Background(), req)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, fakeMounter.MountPoints, 1)
+}
+
+func TestNodeStageBlockVolume(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+	stageVolume(t, nodeSvc, blockCapability())
+	assert.Empty(t, fakeMounter.MountPoints)
+}
+
+func TestNodeUnstageVolume(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeUnstageVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+	staging := stageVolume(t, nodeSvc, mountCapability("ext4"))
+
+	req := &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "vg0/lv0",
+		StagingTargetPath: staging,
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := nodeSvc.NodeUnstageVolume(context.Background(), req)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+	}
+	assert.Empty(t, fakeMounter.MountPoints)
+
+	// A missing staging path is already unstaged
+	req.StagingTargetPath = filepath.Join(t.TempDir(), "missing")
+	_, err := nodeSvc.NodeUnstageVolume(context.Background(), req)
+	assert.NoError(t, err)
+
+	_, err = nodeSvc.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vg0/lv0"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestNodePublishVolume(t *testing.T) {
-	nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001")
-	req := &csi.NodePublishVolumeRequest{}
+	tests := []struct {
+		desc         string
+		req          *csi.NodePublishVolumeRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc: "missing volume id",
+			req: &csi.NodePublishVolumeRequest{
+				TargetPath:       "target",
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing target path",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         "vg0/lv0",
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing volume capability",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:   "vg0/lv0",
+				TargetPath: "target",
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "malformed volume id",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         "lv0",
+				TargetPath:       "target",
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "missing staging path",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         "vg0/lv0",
+				TargetPath:       "target",
+				VolumeCapability: mountCapability("ext4"),
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc: "volume not staged",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          "vg0/lv0",
+				StagingTargetPath: "staging",
+				TargetPath:        "target",
+				VolumeCapability:  mountCapability("ext4"),
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+			// Keep the mounts inside the test's temporary directory
+			tmp := t.TempDir()
+			if test.req.TargetPath != "" {
+				test.req.TargetPath = filepath.Join(tmp, test.req.TargetPath)
+			}
+			if test.req.StagingTargetPath != "" {
+				test.req.StagingTargetPath = filepath.Join(tmp, test.req.StagingTargetPath)
+			}
+
+			resp, err := nodeSvc.NodePublishVolume(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.Nil(t, resp)
+		})
+	}
+}
+
+func TestNodePublishStagedVolume(t *testing.T) {
+	for _, readonly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("readonly %v", readonly), func(t *testing.T) {
+			fakeMounter, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+			staging := stageVolume(t, nodeSvc, mountCapability("ext4"))
+
+			req := &csi.NodePublishVolumeRequest{
+				VolumeId:          "vg0/lv0",
+				StagingTargetPath: staging,
+				TargetPath:        filepath.Join(t.TempDir(), "target"),
+				VolumeCapability:  mountCapability("ext4"),
+				Readonly:          readonly,
+			}
+
+			for i := 0; i < 2; i++ {
+				resp, err := nodeSvc.NodePublishVolume(context.Background(), req)
+				assert.NoError(t, err)
+				assert.NotNil(t, resp)
+			}
+
+			assert.DirExists(t, req.TargetPath)
+			assert.Len(t, fakeMounter.MountPoints, 2)
 
-	resp, err := nodeSvc.NodePublishVolume(context.Background(), req)
-	assert.Nil(t, resp)
-	assert.ErrorContains(t, err, "Unimplemented")
+			// The bind mount resolves back to the LV the staging path holds
+			published := fakeMounter.MountPoints[1]
+			assert.Equal(t, "/dev/vg0/lv0", published.Device)
+			assert.Equal(t, req.TargetPath, published.Path)
+			assert.Contains(t, published.Opts, "bind")
+			if readonly {
+				assert.Contains(t, published.Opts, "ro")
+			} else {
+				assert.NotContains(t, published.Opts, "ro")
+			}
+		})
+	}
 }
 
 func TestNodeUnpublishVolume(t *testing.T) {
-	nodeSvc := services.NewNodeService("NodeUnpublishVolumeSvc", "node_001")
-	req := &csi.NodeUnpublishVolumeRequest{}
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeUnpublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+	staging := stageVolume(t, nodeSvc, mountCapability("ext4"))
+	target := filepath.Join(t.TempDir(), "target")
+
+	_, err := nodeSvc.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "vg0/lv0",
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	assert.NoError(t, err)
+
+	req := &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "vg0/lv0",
+		TargetPath: target,
+	}
 
 	resp, err := nodeSvc.NodeUnpublishVolume(context.Background(), req)
-	assert.Nil(t, resp)
-	assert.ErrorContains(t, err, "Unimplemented")
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Len(t, fakeMounter.MountPoints, 1, "only the staging mount should remain")
+	assert.NoDirExists(t, target)
+
+	// A second unpublish of the now missing target must still succeed
+	resp, err = nodeSvc.NodeUnpublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+}
+
+func TestNodeUnpublishVolumeInvalidArgs(t *testing.T) {
+	_, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodeUnpublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+	_, err := nodeSvc.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{TargetPath: "target"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = nodeSvc.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vg0/lv0"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+}
+
+func TestNodePublishBlockVolume(t *testing.T) {
+	fakeMounter, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+	target := filepath.Join(t.TempDir(), "volumeDevices", "lv0")
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "vg0/lv0",
+		TargetPath:       target,
+		VolumeCapability: blockCapability(),
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := nodeSvc.NodePublishVolume(context.Background(), req)
+		assert.NoError(t, err)
+	}
+
+	assert.FileExists(t, target)
+	assert.Len(t, fakeMounter.MountPoints, 1)
+	assert.Equal(t, "/dev/vg0/lv0", fakeMounter.MountPoints[0].Device)
+	assert.Equal(t, target, fakeMounter.MountPoints[0].Path)
+	assert.Contains(t, fakeMounter.MountPoints[0].Opts, "bind")
+
+	// No format should ever be attempted on a block volume
+	for _, action := range fakeMounter.GetLog() {
+		assert.Equal(t, mount.FakeActionMount, action.Action)
+		assert.Empty(t, action.FSType)
+	}
+
+	_, err := nodeSvc.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "vg0/lv0",
+		TargetPath: target,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, fakeMounter.MountPoints)
+	assert.NoFileExists(t, target)
+}
+
+func TestNodePublishBlockVolumeOnDirectory(t *testing.T) {
+	_, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+	_, err := nodeSvc.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "vg0/lv0",
+		TargetPath:       t.TempDir(),
+		VolumeCapability: blockCapability(),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestNodePublishVolumeMissingAccessType(t *testing.T) {
+	_, mounter := newFakeMounter()
+	nodeSvc := services.NewNodeService("NodePublishVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+	_, err := nodeSvc.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "vg0/lv0",
+		TargetPath:       filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestNodeStageVolumeActivation(t *testing.T) {
+	tests := []struct {
+		desc         string
+		volumeId     string
+		active       bool
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "missing volume",
+			volumeId:     "vg0/lv1",
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:     "inactive volume",
+			volumeId: "vg0/lv0",
+		},
+		{
+			desc:     "active volume",
+			volumeId: "vg0/lv0",
+			active:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeLvm()
+			assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "lv0", test.active))
+			_, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodeStageVolumeSvc", "node_001", newDeviceClasses(t), mounter, fakeLvm)
+
+			_, err := nodeSvc.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          test.volumeId,
+				StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+				VolumeCapability:  mountCapability("ext4"),
+			})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			if test.expectedCode == codes.OK {
+				lv, err := fakeLvm.GetLogicalVolume(context.Background(), "vg0", "lv0")
+				assert.NoError(t, err)
+				assert.True(t, lv.Active())
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	tests := []struct {
+		desc          string
+		blkid         string
+		expectedCalls [][]string
+	}{
+		{
+			desc:  "ext4",
+			blkid: "TYPE=ext4\n",
+			expectedCalls: [][]string{
+				{"resize2fs", "/dev/vg0/lv0"},
+			},
+		},
+		{
+			desc:  "xfs",
+			blkid: "TYPE=xfs\n",
+			expectedCalls: [][]string{
+				{"xfs_growfs", "-d", "<staging>"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodeExpandVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+			staging := stageVolume(t, nodeSvc, mountCapability(test.desc))
+
+			// blkid reports the filesystem, the resize tool is recorded
+			var calls [][]string
+			fakeCmd := func(output string) testingexec.FakeCommandAction {
+				return func(cmd string, args ...string) exec.Cmd {
+					if cmd != "blkid" {
+						calls = append(calls, append([]string{cmd}, args...))
+					}
+					return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+						CombinedOutputScript: []testingexec.FakeAction{
+							func() ([]byte, []byte, error) { return []byte(output), nil, nil },
+						},
+					}, cmd, args...)
+				}
+			}
+			mounter.Exec = &testingexec.FakeExec{
+				CommandScript: []testingexec.FakeCommandAction{fakeCmd(test.blkid), fakeCmd("")},
+			}
+
+			resp, err := nodeSvc.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:   "vg0/lv0",
+				VolumePath: staging,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(8*mib), resp.CapacityBytes)
+
+			for _, call := range test.expectedCalls {
+				for i := range call {
+					if call[i] == "<staging>" {
+						call[i] = staging
+					}
+				}
+			}
+			assert.Equal(t, test.expectedCalls, calls)
+		})
+	}
+}
+
+func TestNodeExpandVolumeErrors(t *testing.T) {
+	tests := []struct {
+		desc         string
+		req          *csi.NodeExpandVolumeRequest
+		expectedCode codes.Code
+	}{
+		{
+			desc:         "missing volume id",
+			req:          &csi.NodeExpandVolumeRequest{VolumePath: "target"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing volume path",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: "vg0/lv0"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing volume",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: "vg0/lv1", VolumePath: "target"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "missing volume path on the node",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: "vg0/lv0", VolumePath: "missing"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			desc:         "volume not mounted",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: "vg0/lv0", VolumePath: "target"},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc: "block volume",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:         "vg0/lv0",
+				VolumePath:       "target",
+				VolumeCapability: blockCapability(),
+			},
+			expectedCode: codes.OK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, mounter := newFakeMounter()
+			nodeSvc := services.NewNodeService("NodeExpandVolumeSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+
+			// Only the target directory exists
+			tmp := t.TempDir()
+			assert.NoError(t, os.Mkdir(filepath.Join(tmp, "target"), 0750))
+			if test.req.VolumePath != "" {
+				test.req.VolumePath = filepath.Join(tmp, test.req.VolumePath)
+			}
+
+			_, err := nodeSvc.NodeExpandVolume(context.Background(), test.req)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	assert.NoError(t, os.Mkdir(staging, 0750))
+	blockTarget := filepath.Join(dir, "block")
+	assert.NoError(t, os.WriteFile(blockTarget, nil, 0660))
+
+	tests := []struct {
+		desc              string
+		req               *csi.NodeGetVolumeStatsRequest
+		inactive          bool
+		readOnly          bool
+		expectedCode      codes.Code
+		expectedUnits     []csi.VolumeUsage_Unit
+		expectedAbnormal  string
+		expectedBlockSize int64
+	}{
+		{
+			desc:          "filesystem",
+			req:           &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0", VolumePath: staging, StagingTargetPath: staging},
+			expectedUnits: []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES, csi.VolumeUsage_INODES},
+		},
+		{
+			desc:              "block",
+			req:               &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0", VolumePath: blockTarget},
+			expectedUnits:     []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES},
+			expectedBlockSize: 8 * mib,
+		},
+		{
+			desc:             "remounted read-only",
+			req:              &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0", VolumePath: staging, StagingTargetPath: staging},
+			readOnly:         true,
+			expectedUnits:    []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES, csi.VolumeUsage_INODES},
+			expectedAbnormal: "is mounted read-only",
+		},
+		{
+			desc:             "inactive",
+			req:              &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0", VolumePath: staging},
+			inactive:         true,
+			expectedUnits:    []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES, csi.VolumeUsage_INODES},
+			expectedAbnormal: "logical volume vg0/lv0 is not active",
+		},
+		{
+			desc:             "missing logical volume",
+			req:              &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv1", VolumePath: staging},
+			expectedAbnormal: "logical volume vg0/lv1 does not exist",
+		},
+		{
+			desc:         "missing volume id",
+			req:          &csi.NodeGetVolumeStatsRequest{VolumePath: staging},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "missing volume path",
+			req:          &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			desc:         "volume path does not exist",
+			req:          &csi.NodeGetVolumeStatsRequest{VolumeId: "vg0/lv0", VolumePath: filepath.Join(dir, "missing")},
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, mounter := newFakeMounter()
+			opts := []string{"rw"}
+			if test.readOnly {
+				opts = []string{"ro"}
+			}
+			fakeMounter.MountPoints = append(fakeMounter.MountPoints, mount.MountPoint{Device: "/dev/vg0/lv0", Path: staging, Type: "ext4", Opts: opts})
+
+			fakeLvm := newFakeLvm()
+			if test.inactive {
+				assert.NoError(t, fakeLvm.SetLogicalVolumeActive(context.Background(), "vg0", "lv0", false))
+			}
+			nodeSvc := services.NewNodeService("NodeGetVolumeStatsSvc", "node_001", newDeviceClasses(t), mounter, fakeLvm)
+
+			resp, err := nodeSvc.NodeGetVolumeStats(context.Background(), test.req)
+			if test.expectedCode != codes.OK {
+				assert.Equal(t, test.expectedCode, status.Code(err))
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var units []csi.VolumeUsage_Unit
+			for _, usage := range resp.Usage {
+				units = append(units, usage.Unit)
+			}
+			assert.Equal(t, test.expectedUnits, units)
+
+			if test.expectedBlockSize > 0 {
+				assert.Equal(t, test.expectedBlockSize, resp.Usage[0].Total)
+			} else if len(resp.Usage) > 0 {
+				bytes := resp.Usage[0]
+				assert.Positive(t, bytes.Total)
+				assert.GreaterOrEqual(t, bytes.Total, bytes.Used)
+			}
+
+			assert.Equal(t, test.expectedAbnormal != "", resp.VolumeCondition.Abnormal)
+			assert.Contains(t, resp.VolumeCondition.Message, test.expectedAbnormal)
+		})
+	}
+}
+
+func TestNodeGetVolumeStatsReadOnlyStaging(t *testing.T) {
+	// blkid reports an existing filesystem, which may be mounted read-only
+	formatted := func(cmd string, args ...string) exec.Cmd {
+		return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+			CombinedOutputScript: []testingexec.FakeAction{
+				func() ([]byte, []byte, error) { return []byte("TYPE=ext4\n"), nil, nil },
+			},
+		}, cmd, args...)
+	}
+
+	tests := []struct {
+		desc             string
+		mountOptions     []string
+		flags            []string
+		remount          bool
+		restart          bool
+		expectedAbnormal bool
+	}{
+		{
+			desc:             "remounted read-only",
+			remount:          true,
+			expectedAbnormal: true,
+		},
+		{
+			desc:  "read-only capability",
+			flags: []string{"ro"},
+		},
+		{
+			desc:         "read-only mount options",
+			mountOptions: []string{"ro"},
+		},
+		{
+			desc:         "read-only mount options before a restart",
+			mountOptions: []string{"ro"},
+			restart:      true,
+		},
+		{
+			desc:             "remounted read-only before a restart",
+			remount:          true,
+			restart:          true,
+			expectedAbnormal: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, mounter := newFakeMounter()
+			mounter.Exec = &testingexec.FakeExec{CommandScript: []testingexec.FakeCommandAction{formatted, formatted}}
+			nodeSvc := services.NewNodeService("NodeGetVolumeStatsSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+			nodeSvc.SetMountDefaults("ext4", test.mountOptions)
+
+			staging := stageVolume(t, nodeSvc, mountCapability("ext4", test.flags...))
+			if !assert.Len(t, fakeMounter.MountPoints, 1) {
+				return
+			}
+			if test.remount {
+				fakeMounter.MountPoints[0].Opts = []string{"ro"}
+			}
+			if test.restart {
+				// The staged filesystem outlives the driver
+				nodeSvc = services.NewNodeService("NodeGetVolumeStatsSvc", "node_001", newDeviceClasses(t), mounter, newFakeLvm())
+				nodeSvc.SetMountDefaults("ext4", test.mountOptions)
+			}
+
+			resp, err := nodeSvc.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:          "vg0/lv0",
+				VolumePath:        staging,
+				StagingTargetPath: staging,
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, test.expectedAbnormal, resp.VolumeCondition.Abnormal, resp.VolumeCondition.Message)
+		})
+	}
 }
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"k8s.io/klog/v2"
+)
+
+// snapshotFillWarning is the percentage of its store a copy-on-write snapshot
+// may fill before the monitor warns about it
+const snapshotFillWarning = 80.0
+
+// SnapshotMonitor watches how full the copy-on-write snapshots of the driver
+// are. Snapshots that overflow are tagged so they are reported as unusable
+// even after lvm stops reporting them as invalid.
+type SnapshotMonitor struct {
+	driverName    string
+	deviceClasses *DeviceClasses
+	interval      time.Duration
+	lvm           lvm.Interface
+}
+
+func NewSnapshotMonitor(name string, deviceClasses *DeviceClasses, lvmClient lvm.Interface, interval time.Duration) *SnapshotMonitor {
+	return &SnapshotMonitor{
+		driverName:    name,
+		deviceClasses: deviceClasses,
+		interval:      interval,
+		lvm:           lvmClient,
+	}
+}
+
+// Run checks the snapshots every interval until ctx is done
+func (m *SnapshotMonitor) Run(ctx context.Context) {
+	logger := klog.FromContext(ctx)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, vg := range m.deviceClasses.VolumeGroups() {
+			if err := m.check(ctx, vg); err != nil {
+				logger.Error(err, "failed to check the snapshots", "vg", vg)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *SnapshotMonitor) check(ctx context.Context, vg string) error {
+	lvs, err := m.lvm.ListLogicalVolumes(ctx, vg)
+	if err != nil {
+		return err
+	}
+
+	logger := klog.FromContext(ctx)
+
+	for _, lv := range lvs {
+		if !lv.CopyOnWrite() {
+			continue
+		}
+
+		tags := parseSnapshotTags(m.driverName, lv)
+		if tags == nil || tags.overflowed {
+			continue
+		}
+
+		snapshotLogger := klog.LoggerWithValues(logger, "snapshot", utils.VolumeID(lv.VG, lv.Name), "origin", tags.sourceVolumeId,
+			"dataPercent", lv.DataPercent, "reserve", lv.Size)
+		switch {
+		case lv.Invalid():
+			snapshotLogger.Error(nil, "snapshot overflowed its reserve and is no longer usable")
+			if err := m.lvm.UpdateLogicalVolumeTags(ctx, lv.VG, lv.Name, []string{overflowedTag(m.driverName)}, nil); err != nil {
+				snapshotLogger.Error(err, "failed to mark snapshot as overflowed")
+			}
+		case lv.DataPercent >= snapshotFillWarning:
+			snapshotLogger.Info("snapshot is filling its reserve")
+		default:
+			snapshotLogger.V(4).Info("snapshot reserve usage")
+		}
+	}
+
+	return nil
+}
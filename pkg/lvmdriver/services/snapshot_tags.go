@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+)
+
+// Tag keys recorded on every snapshot created by the driver
+const (
+	snapshotTagKey = "snapshot"
+	sourceTagKey   = "source"
+	// overflowedTagKey marks a copy-on-write snapshot that ran out of space
+	overflowedTagKey = "overflowed"
+)
+
+// snapshotTags holds what the driver records about the CreateSnapshot request of a snapshot
+type snapshotTags struct {
+	name           string
+	sourceVolumeId string
+	overflowed     bool
+}
+
+// parseSnapshotTags reads the driver's tags from a snapshot, returning nil
+// when the snapshot was not created by the driver
+func parseSnapshotTags(driverName string, lv *lvm.LogicalVolume) *snapshotTags {
+	tags := driverTags(driverName, lv)
+	if _, owned := tags[snapshotTagKey]; !owned {
+		return nil
+	}
+
+	_, overflowed := tags[overflowedTagKey]
+
+	return &snapshotTags{
+		name:           tags[snapshotTagKey],
+		sourceVolumeId: tags[sourceTagKey],
+		overflowed:     overflowed,
+	}
+}
+
+// overflowedTag returns the tag marking a snapshot that ran out of space
+func overflowedTag(driverName string) string {
+	return fmt.Sprintf("%s/%s=true", driverName, overflowedTagKey)
+}
+
+// lvmTags formats the tags to pass to lvcreate
+func (t *snapshotTags) lvmTags(driverName string) ([]string, error) {
+	tags := []string{
+		fmt.Sprintf("%s/%s=%s", driverName, snapshotTagKey, t.name),
+		fmt.Sprintf("%s/%s=%s", driverName, sourceTagKey, t.sourceVolumeId),
+	}
+
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %s exceeds the maximum length of %d", tag, maxTagLength)
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/utils"
+	"k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+// endpointDialTimeout bounds connecting to the endpoint of the driver
+const endpointDialTimeout = 5 * time.Second
+
+// LvmToolsCheck checks that the lvm commands the driver runs are installed
+// and run, which they do not when e.g. a library they link is missing
+func LvmToolsCheck(exec utilexec.Interface) StatusCheck {
+	return StatusCheck{
+		Name:     "lvmTools",
+		Liveness: true,
+		Check: func(ctx context.Context) error {
+			var errs []error
+			for _, command := range lvm.Commands {
+				if _, err := exec.LookPath(command); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if len(errs) > 0 {
+				return errors.Join(errs...)
+			}
+
+			// The commands are links to the same lvm binary
+			if out, err := exec.CommandContext(ctx, lvm.Commands[0], "--version").CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to run %s: %v, output: %s", lvm.Commands[0], err, out)
+			}
+			return nil
+		},
+	}
+}
+
+// VolumeGroupsCheck checks that the volume groups of the device classes can
+// be reported by lvm and none of their physical volumes is missing
+func VolumeGroupsCheck(deviceClasses *DeviceClasses, lvmClient lvm.Interface) StatusCheck {
+	return StatusCheck{
+		Name:     "volumeGroups",
+		Liveness: true,
+		Check: func(ctx context.Context) error {
+			var errs []error
+			for _, vg := range deviceClasses.VolumeGroups() {
+				report, err := lvmClient.GetVolumeGroup(ctx, vg)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("volume group %s: %v", vg, err))
+					continue
+				}
+				if report.Partial() {
+					errs = append(errs, fmt.Errorf("volume group %s is partial, one or more of its physical volumes are missing", vg))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
+
+// ThinPoolsCheck checks that the thin pools of the device classes exist and
+// neither their data nor their metadata is criticalPercent full or more.
+// lvm suspends the volumes of a full pool until it is extended.
+func ThinPoolsCheck(deviceClasses *DeviceClasses, lvmClient lvm.Interface, criticalPercent float64) StatusCheck {
+	return StatusCheck{
+		Name: "thinPools",
+		Check: func(ctx context.Context) error {
+			var errs []error
+			checked := map[string]bool{}
+			for _, class := range deviceClasses.List() {
+				id := utils.VolumeID(class.VolumeGroup, class.ThinPool)
+				if class.ThinPool == "" || checked[id] {
+					continue
+				}
+				// Pools shared by several classes are only checked once
+				checked[id] = true
+
+				pool, err := lvmClient.GetLogicalVolume(ctx, class.VolumeGroup, class.ThinPool)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("thin pool %s: %v", id, err))
+					continue
+				}
+				if pool.DataPercent >= criticalPercent {
+					errs = append(errs, fmt.Errorf("thin pool %s data is %.2f%% full, at least %.2f%% is critical", id, pool.DataPercent, criticalPercent))
+				}
+				if pool.MetadataPercent >= criticalPercent {
+					errs = append(errs, fmt.Errorf("thin pool %s metadata is %.2f%% full, at least %.2f%% is critical", id, pool.MetadataPercent, criticalPercent))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
+
+// MountPointsCheck checks that each of paths is a mount point, as the host
+// directories the driver works in are mounted into its container
+func MountPointsCheck(mounter mount.Interface, paths ...string) StatusCheck {
+	return StatusCheck{
+		Name: "mountPoints",
+		Check: func(ctx context.Context) error {
+			mountPoints, err := mounter.List()
+			if err != nil {
+				return fmt.Errorf("failed to list mount points: %v", err)
+			}
+
+			mounted := map[string]bool{}
+			for _, mountPoint := range mountPoints {
+				mounted[mountPoint.Path] = true
+			}
+
+			var errs []error
+			for _, path := range paths {
+				if !mounted[path] {
+					errs = append(errs, fmt.Errorf("%s is not mounted", path))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
+
+// EndpointCheck checks that the gRPC server accepts connections on endpoint
+func EndpointCheck(endpoint string) StatusCheck {
+	return StatusCheck{
+		Name:     "endpoint",
+		Liveness: true,
+		Check: func(ctx context.Context) error {
+			proto, addr, err := utils.ParseEndpoint(endpoint)
+			if err != nil {
+				return err
+			}
+			if proto == "unix" {
+				addr = "/" + addr
+			}
+
+			dialer := net.Dialer{Timeout: endpointDialTimeout}
+			conn, err := dialer.DialContext(ctx, proto, addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
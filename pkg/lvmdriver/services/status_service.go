@@ -1,15 +1,115 @@
 package services
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// statusCheckTimeout bounds a run of the status checks
+const statusCheckTimeout = 10 * time.Second
+
+// StatusCheck checks a dependency of the driver
+type StatusCheck struct {
+	// Name identifies the check in status reports
+	Name string
+	// Liveness marks the checks of what the driver cannot serve without: the
+	// lvm tools, the volume groups and the endpoint. The others only make the
+	// driver unready.
+	Liveness bool
+	Check    func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a StatusCheck, Error is empty if it passed
+type CheckResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// StatusService runs the status checks of the driver for the Probe RPC and
+// the health server. The checks run lvm commands, their results are cached
+// for ttl so frequent probes stay cheap.
 type StatusService struct {
+	checks []StatusCheck
+	ttl    time.Duration
+
+	mtx sync.Mutex // Guards the cached results, held while the checks run
+	// results of the last run of the checks, in the order of checks
+	results []checkRun
+	// checked is when the checks last ran
+	checked time.Time
+}
+
+// checkRun is the outcome of a run of a StatusCheck
+type checkRun struct {
+	check StatusCheck
+	err   error
+}
+
+// NewStatusService returns a StatusService running checks, caching their
+// results for ttl. A ttl of 0 runs the checks on every call.
+func NewStatusService(ttl time.Duration, checks ...StatusCheck) *StatusService {
+	return &StatusService{
+		checks: checks,
+		ttl:    ttl,
+	}
+}
+
+// Check runs the checks, or reuses their results while they are cached, and
+// returns the results of the liveness ones if liveness is set or else of all
+// of them, in order, along with an error joining the failures
+func (s *StatusService) Check(liveness bool) ([]CheckResult, error) {
+	results := []CheckResult{}
+	var errs []error
+	for _, run := range s.run() {
+		if liveness && !run.check.Liveness {
+			continue
+		}
+
+		result := CheckResult{Name: run.check.Name}
+		if run.err != nil {
+			result.Error = run.err.Error()
+			errs = append(errs, fmt.Errorf("%s: %v", run.check.Name, run.err))
+		}
+		results = append(results, result)
+	}
+
+	return results, errors.Join(errs...)
 }
 
-func NewStatusService() StatusService {
-	return StatusService{}
+// run returns the cached results of the checks, running them once expired.
+// The results are shared by the callers, so the checks do not run in the
+// context of the caller whose cancellation would be cached.
+func (s *StatusService) run() []checkRun {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.results != nil && time.Since(s.checked) < s.ttl {
+		return s.results
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusCheckTimeout)
+	defer cancel()
+
+	results := make([]checkRun, 0, len(s.checks))
+	for _, check := range s.checks {
+		results = append(results, checkRun{check: check, err: check.Check(ctx)})
+	}
+
+	s.results = results
+	s.checked = time.Now()
+	return results
 }
 
 // Ready will check and validate that the driver is ready
 //
 // if the driver is not ready an error will be reported explaining the cause of unready
-func (s StatusService) Ready() (bool, error) {
+func (s *StatusService) Ready() (bool, error) {
+	if _, err := s.Check(false); err != nil {
+		return false, err
+	}
+
 	return true, nil
 }
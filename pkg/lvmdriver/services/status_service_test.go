@@ -0,0 +1,236 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// staticCheck returns a check failing with err, if any
+func staticCheck(name string, liveness bool, err error) services.StatusCheck {
+	return services.StatusCheck{
+		Name:     name,
+		Liveness: liveness,
+		Check: func(ctx context.Context) error {
+			return err
+		},
+	}
+}
+
+func TestStatusServiceCheck(t *testing.T) {
+	statusSvc := services.NewStatusService(0,
+		staticCheck("tools", true, nil),
+		staticCheck("storage", false, fmt.Errorf("volume group vg0 is missing")),
+	)
+
+	results, err := statusSvc.Check(false)
+	assert.EqualError(t, err, "storage: volume group vg0 is missing")
+	assert.Equal(t, []services.CheckResult{
+		{Name: "tools"},
+		{Name: "storage", Error: "volume group vg0 is missing"},
+	}, results)
+
+	results, err = statusSvc.Check(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []services.CheckResult{{Name: "tools"}}, results)
+
+	ready, err := statusSvc.Ready()
+	assert.Error(t, err)
+	assert.False(t, ready)
+
+	ready, err = services.NewStatusService(0, staticCheck("tools", true, nil)).Ready()
+	assert.NoError(t, err)
+	assert.True(t, ready)
+}
+
+func TestStatusServiceCache(t *testing.T) {
+	var runs int
+	counting := services.StatusCheck{
+		Name: "counting",
+		Check: func(ctx context.Context) error {
+			runs++
+			return nil
+		},
+	}
+
+	cached := services.NewStatusService(time.Hour, counting)
+	for i := 0; i < 3; i++ {
+		_, err := cached.Check(i%2 == 0)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, runs, "the checks should run once while cached")
+
+	runs = 0
+	uncached := services.NewStatusService(0, counting)
+	for i := 0; i < 3; i++ {
+		_, err := uncached.Ready()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, runs, "the checks should run on every call without a ttl")
+}
+
+func TestLvmToolsCheck(t *testing.T) {
+	tests := []struct {
+		desc        string
+		missing     string
+		runErr      error
+		expectedErr string
+	}{
+		{
+			desc: "installed",
+		},
+		{
+			desc:        "lvcreate missing",
+			missing:     "lvcreate",
+			expectedErr: "lvcreate not found",
+		},
+		{
+			desc:        "failing to run",
+			runErr:      fmt.Errorf("exit status 127"),
+			expectedErr: "failed to run vgs: exit status 127",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeExec := &testingexec.FakeExec{
+				LookPathFunc: func(file string) (string, error) {
+					if file == test.missing {
+						return "", fmt.Errorf("%s not found in $PATH", file)
+					}
+					return "/usr/sbin/" + file, nil
+				},
+				CommandScript: []testingexec.FakeCommandAction{
+					func(cmd string, args ...string) exec.Cmd {
+						return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+							CombinedOutputScript: []testingexec.FakeAction{
+								func() ([]byte, []byte, error) { return []byte("LVM version: 2.03.21"), nil, test.runErr },
+							},
+						}, cmd, args...)
+					},
+				},
+			}
+
+			err := services.LvmToolsCheck(fakeExec).Check(context.Background())
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, fakeExec.CommandCalls)
+			}
+		})
+	}
+}
+
+func TestVolumeGroupsCheck(t *testing.T) {
+	deviceClasses := newDeviceClasses(t,
+		&services.DeviceClass{Name: "fast", VolumeGroup: "vg0", Default: true},
+		&services.DeviceClass{Name: "bulk", VolumeGroup: "vg1"},
+	)
+
+	check := services.VolumeGroupsCheck(deviceClasses, newFakeVolumeGroup())
+	// /healthz runs it along with the lvm tools and endpoint checks
+	assert.True(t, check.Liveness)
+	assert.ErrorContains(t, check.Check(context.Background()), "volume group vg1")
+
+	fakeLvm := newFakeVolumeGroup()
+	fakeLvm.AddVolumeGroup("vg1", vgSize, extentSize)
+	check = services.VolumeGroupsCheck(deviceClasses, fakeLvm)
+	assert.NoError(t, check.Check(context.Background()))
+
+	assert.NoError(t, fakeLvm.SetPartial("vg1", true))
+	assert.EqualError(t, check.Check(context.Background()), "volume group vg1 is partial, one or more of its physical volumes are missing")
+}
+
+func TestThinPoolsCheck(t *testing.T) {
+	deviceClasses := newDeviceClasses(t,
+		&services.DeviceClass{Name: "thin", VolumeGroup: "vg0", ThinPool: "pool0", Default: true},
+		&services.DeviceClass{Name: "thin-xfs", VolumeGroup: "vg0", ThinPool: "pool0", FsType: "xfs"},
+		&services.DeviceClass{Name: "thick", VolumeGroup: "vg0"},
+	)
+
+	tests := []struct {
+		desc            string
+		noPool          bool
+		dataPercent     float64
+		metadataPercent float64
+		expectedErr     string
+	}{
+		{
+			desc:            "below the threshold",
+			dataPercent:     94.9,
+			metadataPercent: 50,
+		},
+		{
+			desc:        "missing pool",
+			noPool:      true,
+			expectedErr: "thin pool vg0/pool0",
+		},
+		{
+			desc:        "data full",
+			dataPercent: 95,
+			expectedErr: "thin pool vg0/pool0 data is 95.00% full, at least 95.00% is critical",
+		},
+		{
+			desc:            "metadata full",
+			metadataPercent: 99.5,
+			expectedErr:     "thin pool vg0/pool0 metadata is 99.50% full, at least 95.00% is critical",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeLvm := newFakeVolumeGroup()
+			if !test.noPool {
+				assert.NoError(t, fakeLvm.AddThinPool("vg0", "pool0", vgSize/2))
+				assert.NoError(t, fakeLvm.SetDataPercent("vg0", "pool0", test.dataPercent))
+				assert.NoError(t, fakeLvm.SetMetadataPercent("vg0", "pool0", test.metadataPercent))
+			}
+
+			err := services.ThinPoolsCheck(deviceClasses, fakeLvm, 95).Check(context.Background())
+			if test.expectedErr != "" {
+				// The pool shared by both classes is reported once
+				assert.ErrorContains(t, err, test.expectedErr)
+				assert.NotContains(t, err.Error(), "\n")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMountPointsCheck(t *testing.T) {
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "devtmpfs", Path: "/dev", Type: "devtmpfs"},
+	})
+
+	check := services.MountPointsCheck(fakeMounter, "/dev")
+	assert.NoError(t, check.Check(context.Background()))
+
+	check = services.MountPointsCheck(fakeMounter, "/dev", "/var/lib/kubelet/pods")
+	assert.EqualError(t, check.Check(context.Background()), "/var/lib/kubelet/pods is not mounted")
+}
+
+func TestEndpointCheck(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	check := services.EndpointCheck("unix://" + socket)
+
+	assert.Error(t, check.Check(context.Background()))
+
+	listener, err := net.Listen("unix", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	assert.NoError(t, check.Check(context.Background()))
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validateVolumeCapabilities checks that every capability requests a supported
+// access type and a single node access mode, and that block and mount access
+// types are not mixed
+func validateVolumeCapabilities(caps []*csi.VolumeCapability) error {
+	if len(caps) == 0 {
+		return status.Error(codes.InvalidArgument, "volume capability is missing from the request")
+	}
+
+	var block, mnt bool
+	for _, c := range caps {
+		if c == nil {
+			return status.Error(codes.InvalidArgument, "volume capability is missing from the request")
+		}
+
+		switch c.GetAccessType().(type) {
+		case *csi.VolumeCapability_Block:
+			block = true
+		case *csi.VolumeCapability_Mount:
+			mnt = true
+		default:
+			return status.Error(codes.InvalidArgument, "volume capability must request either block or mount access")
+		}
+
+		switch c.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			return status.Errorf(codes.InvalidArgument, "access mode %s is not supported by node local volumes", c.GetAccessMode().GetMode())
+		}
+	}
+
+	if block && mnt {
+		return status.Error(codes.InvalidArgument, "block and mount access types cannot be mixed")
+	}
+
+	return nil
+}
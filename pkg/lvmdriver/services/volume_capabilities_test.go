@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	block := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	mnt := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	tests := []struct {
+		desc      string
+		caps      []*csi.VolumeCapability
+		expectErr bool
+	}{
+		{
+			desc: "block access",
+			caps: []*csi.VolumeCapability{block},
+		},
+		{
+			desc: "mount access",
+			caps: []*csi.VolumeCapability{mnt, mnt},
+		},
+		{
+			desc:      "no capabilities",
+			expectErr: true,
+		},
+		{
+			desc:      "nil capability",
+			caps:      []*csi.VolumeCapability{nil},
+			expectErr: true,
+		},
+		{
+			desc:      "missing access type",
+			caps:      []*csi.VolumeCapability{{}},
+			expectErr: true,
+		},
+		{
+			desc: "multi node access mode",
+			caps: []*csi.VolumeCapability{{
+				AccessType: mnt.AccessType,
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			}},
+			expectErr: true,
+		},
+		{
+			desc:      "mixed block and mount access",
+			caps:      []*csi.VolumeCapability{block, mnt},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := validateVolumeCapabilities(test.caps)
+
+			if test.expectErr {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/openshift/lvm-driver/pkg/lvm"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/metrics"
+)
+
+// VolumeGroupStats returns the function reporting the usage of the volume
+// groups and thin pools of the device classes, and the number of volumes and
+// snapshots the driver created in them
+func VolumeGroupStats(driverName string, deviceClasses *DeviceClasses, lvmClient lvm.Interface) metrics.VolumeGroupStatsFunc {
+	return func(ctx context.Context) ([]metrics.VolumeGroupStats, error) {
+		var stats []metrics.VolumeGroupStats
+		var errs []error
+		for _, name := range deviceClasses.VolumeGroups() {
+			vg, err := lvmClient.GetVolumeGroup(ctx, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("volume group %s: %v", name, err))
+				continue
+			}
+
+			lvs, err := lvmClient.ListLogicalVolumes(ctx, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("volume group %s: %v", name, err))
+				continue
+			}
+
+			pools := map[string]bool{}
+			for _, class := range deviceClasses.List() {
+				if class.VolumeGroup == name && class.ThinPool != "" {
+					pools[class.ThinPool] = true
+				}
+			}
+
+			vgStats := metrics.VolumeGroupStats{Name: name, Size: vg.Size, Free: vg.Free}
+			for _, lv := range lvs {
+				switch {
+				case pools[lv.Name] && lv.ThinPool():
+					vgStats.ThinPools = append(vgStats.ThinPools, metrics.ThinPoolStats{
+						Name:            lv.Name,
+						DataPercent:     lv.DataPercent,
+						MetadataPercent: lv.MetadataPercent,
+					})
+				case parseVolumeTags(driverName, lv) != nil:
+					vgStats.Volumes++
+				case parseSnapshotTags(driverName, lv) != nil:
+					vgStats.Snapshots++
+				}
+			}
+			stats = append(stats, vgStats)
+		}
+
+		return stats, errors.Join(errs...)
+	}
+}
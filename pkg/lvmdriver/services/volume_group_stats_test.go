@@ -0,0 +1,45 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/metrics"
+	"github.com/openshift/lvm-driver/pkg/lvmdriver/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeGroupStats(t *testing.T) {
+	ctx := context.Background()
+	deviceClasses := newDeviceClasses(t,
+		&services.DeviceClass{Name: "thin", VolumeGroup: "vg0", ThinPool: "pool0", Default: true},
+		&services.DeviceClass{Name: "bulk", VolumeGroup: "vg1"},
+	)
+
+	fakeLvm := newFakeThinVolume(t)
+	assert.NoError(t, fakeLvm.SetDataPercent("vg0", "pool0", 40))
+	assert.NoError(t, fakeLvm.SetMetadataPercent("vg0", "pool0", 5))
+	// Volumes another driver created are not counted
+	createLogicalVolume(t, fakeLvm, "other", 8*mib, "other.driver/name=other")
+
+	controllerSvc := services.NewControllerService("SnapshotSvc", "node_001", deviceClasses, fakeLvm, nil, nil)
+	_, err := controllerSvc.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "vg0/pvc-1"})
+	assert.NoError(t, err)
+
+	stats, err := services.VolumeGroupStats("SnapshotSvc", deviceClasses, fakeLvm)(ctx)
+	assert.ErrorContains(t, err, "volume group vg1")
+
+	vg, err := fakeLvm.GetVolumeGroup(ctx, "vg0")
+	assert.NoError(t, err)
+	assert.Equal(t, []metrics.VolumeGroupStats{
+		{
+			Name:      "vg0",
+			Size:      vg.Size,
+			Free:      vg.Free,
+			ThinPools: []metrics.ThinPoolStats{{Name: "pool0", DataPercent: 40, MetadataPercent: 5}},
+			Volumes:   2,
+			Snapshots: 1,
+		},
+	}, stats)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StorageClass parameters understood by the driver. Parameters with other
+// keys, such as the csi.storage.k8s.io metadata added by the provisioner,
+// are ignored.
+const (
+	// thinPoolParam names the thin pool in the volume group to provision from
+	thinPoolParam = "thinPool"
+	// overprovisionRatioParam is how many times the size of the thin pool
+	// may be handed out to thin volumes
+	overprovisionRatioParam = "overprovisionRatio"
+	// snapshotReserveParam sizes the copy-on-write snapshots of thick volumes,
+	// in bytes or as a percentage of the volume such as "20%". It is read from
+	// the VolumeSnapshotClass, falling back to the StorageClass of the volume.
+	snapshotReserveParam = "snapshotReserve"
+	// deviceClassParam selects the device class to provision from, the
+	// default device class of the node when it is missing
+	deviceClassParam = "deviceClass"
+)
+
+const (
+	defaultOverprovisionRatio = 1.0
+	defaultSnapshotReserve    = "10%"
+)
+
+// volumeParameters holds the StorageClass parameters of a request
+type volumeParameters struct {
+	thinPool           string
+	overprovisionRatio float64
+	snapshotReserve    *snapshotReserve
+}
+
+// snapshotReserve is the size of the store holding the blocks a copy-on-write
+// snapshot keeps from its origin, in bytes or as a percentage of the origin
+type snapshotReserve struct {
+	bytes   uint64
+	percent float64
+}
+
+func parseVolumeParameters(params map[string]string) (*volumeParameters, error) {
+	p := &volumeParameters{
+		thinPool:           params[thinPoolParam],
+		overprovisionRatio: defaultOverprovisionRatio,
+	}
+
+	if value, ok := params[overprovisionRatioParam]; ok {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 1 {
+			return nil, fmt.Errorf("parameter %s must be a number of at least 1, got %q", overprovisionRatioParam, value)
+		}
+		p.overprovisionRatio = ratio
+	}
+
+	reserve, ok := params[snapshotReserveParam]
+	if !ok {
+		reserve = defaultSnapshotReserve
+	}
+
+	var err error
+	p.snapshotReserve, err = parseSnapshotReserve(reserve)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parseSnapshotReserve(value string) (*snapshotReserve, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("parameter %s must be a percentage above 0 and at most 100, got %q", snapshotReserveParam, value)
+		}
+		return &snapshotReserve{percent: p}, nil
+	}
+
+	bytes, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || bytes == 0 {
+		return nil, fmt.Errorf("parameter %s must be a number of bytes or a percentage, got %q", snapshotReserveParam, value)
+	}
+
+	return &snapshotReserve{bytes: bytes}, nil
+}
+
+// size returns the bytes to reserve for a snapshot of an origin of originSize
+// bytes, rounded up to a whole number of extents
+func (r *snapshotReserve) size(originSize uint64, extentSize uint64) uint64 {
+	size := r.bytes
+	if r.percent > 0 {
+		size = uint64(float64(originSize) * r.percent / 100)
+	}
+
+	extents := (size + extentSize - 1) / extentSize
+	if extents == 0 {
+		extents = 1
+	}
+
+	return extents * extentSize
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openshift/lvm-driver/pkg/lvm"
+)
+
+// maxTagLength is the longest tag lvm accepts
+const maxTagLength = 1024
+
+// Tag keys recorded on every logical volume created by the driver.
+// Tags take the form <driver name>/<key>=<value>.
+const (
+	nameTagKey     = "name"
+	capacityTagKey = "capacity"
+	paramsTagKey   = "params"
+	// contentTagKey records the snapshot or volume a volume was populated from
+	contentTagKey = "content"
+	// populatingTagKey marks a volume whose content is still being copied
+	populatingTagKey = "populating"
+	// deviceClassTagKey records the device class the volume was provisioned from
+	deviceClassTagKey = "deviceclass"
+)
+
+// volumeTags holds what the driver records about the CreateVolume request of a volume
+type volumeTags struct {
+	name        string
+	capacity    string
+	params      string
+	content     string
+	deviceClass string
+	populating  bool
+}
+
+// newVolumeTags derives the tags for a CreateVolume request. The parameters are
+// stored as base64 encoded JSON since lvm restricts the characters of a tag.
+func newVolumeTags(req *csi.CreateVolumeRequest, class *DeviceClass) (*volumeTags, error) {
+	params := ""
+	if len(req.GetParameters()) > 0 {
+		// json sorts map keys so equal parameters always encode the same
+		encoded, err := json.Marshal(req.GetParameters())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode parameters: %v", err)
+		}
+		params = base64.RawURLEncoding.EncodeToString(encoded)
+	}
+
+	return &volumeTags{
+		name:        req.GetName(),
+		capacity:    fmt.Sprintf("%d:%d", req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes()),
+		params:      params,
+		content:     contentSourceTag(req.GetVolumeContentSource()),
+		deviceClass: class.Name,
+	}, nil
+}
+
+// parseVolumeTags reads the driver's tags from a logical volume, returning nil
+// when the volume was not created by the driver
+func parseVolumeTags(driverName string, lv *lvm.LogicalVolume) *volumeTags {
+	tags := driverTags(driverName, lv)
+	if _, owned := tags[nameTagKey]; !owned {
+		return nil
+	}
+
+	_, populating := tags[populatingTagKey]
+
+	return &volumeTags{
+		name:        tags[nameTagKey],
+		capacity:    tags[capacityTagKey],
+		params:      tags[paramsTagKey],
+		content:     tags[contentTagKey],
+		deviceClass: tags[deviceClassTagKey],
+		populating:  populating,
+	}
+}
+
+// contentSourceTag formats the content source of a request as snapshot:<id> or
+// volume:<id>, returning an empty string for an empty volume
+func contentSourceTag(source *csi.VolumeContentSource) string {
+	switch {
+	case source.GetSnapshot() != nil:
+		return "snapshot:" + source.GetSnapshot().GetSnapshotId()
+	case source.GetVolume() != nil:
+		return "volume:" + source.GetVolume().GetVolumeId()
+	default:
+		return ""
+	}
+}
+
+// contentSource parses a content source formatted by contentSourceTag,
+// returning nil for an empty volume
+func contentSource(tag string) *csi.VolumeContentSource {
+	kind, id, _ := strings.Cut(tag, ":")
+	switch kind {
+	case "snapshot":
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+			},
+		}
+	case "volume":
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id},
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// populatingTag returns the tag marking a volume whose content is still being copied
+func populatingTag(driverName string) string {
+	return fmt.Sprintf("%s/%s=true", driverName, populatingTagKey)
+}
+
+// driverTags returns the key and value of every tag of the driver on a logical volume
+func driverTags(driverName string, lv *lvm.LogicalVolume) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range lv.Tags {
+		key, value, ok := strings.Cut(strings.TrimPrefix(tag, driverName+"/"), "=")
+		if !ok || !strings.HasPrefix(tag, driverName+"/") {
+			continue
+		}
+		tags[key] = value
+	}
+
+	return tags
+}
+
+// parameters decodes the StorageClass parameters the volume was created with
+func (t *volumeTags) parameters() (map[string]string, error) {
+	params := map[string]string{}
+	if t.params == "" {
+		return params, nil
+	}
+
+	encoded, err := base64.RawURLEncoding.DecodeString(t.params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode parameters: %v", err)
+	}
+
+	if err := json.Unmarshal(encoded, &params); err != nil {
+		return nil, fmt.Errorf("failed to decode parameters: %v", err)
+	}
+
+	return params, nil
+}
+
+// lvmTags formats the tags to pass to lvcreate
+func (t *volumeTags) lvmTags(driverName string) ([]string, error) {
+	tags := []string{
+		fmt.Sprintf("%s/%s=%s", driverName, nameTagKey, t.name),
+		fmt.Sprintf("%s/%s=%s", driverName, capacityTagKey, t.capacity),
+	}
+
+	if t.params != "" {
+		tags = append(tags, fmt.Sprintf("%s/%s=%s", driverName, paramsTagKey, t.params))
+	}
+
+	if t.content != "" {
+		tags = append(tags, fmt.Sprintf("%s/%s=%s", driverName, contentTagKey, t.content))
+	}
+
+	if t.deviceClass != "" {
+		tags = append(tags, fmt.Sprintf("%s/%s=%s", driverName, deviceClassTagKey, t.deviceClass))
+	}
+
+	if t.populating {
+		tags = append(tags, populatingTag(driverName))
+	}
+
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %s exceeds the maximum length of %d", tag, maxTagLength)
+		}
+	}
+
+	return tags, nil
+}
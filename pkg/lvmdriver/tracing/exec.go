@@ -0,0 +1,148 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	utilexec "k8s.io/utils/exec"
+)
+
+// exitCodeKey is the attribute holding the exit code of a command
+const exitCodeKey = attribute.Key("process.exit.code")
+
+// tracedExec runs commands in child spans of the span of their context, or
+// of parent for the commands run without a context
+type tracedExec struct {
+	utilexec.Interface
+	tracer trace.Tracer
+	parent context.Context
+}
+
+var _ utilexec.Interface = &tracedExec{}
+
+func (e *tracedExec) Command(cmd string, args ...string) utilexec.Cmd {
+	return &tracedCmd{
+		Cmd:    e.Interface.Command(cmd, args...),
+		tracer: e.tracer,
+		ctx:    e.parent,
+		argv:   append([]string{cmd}, args...),
+	}
+}
+
+func (e *tracedExec) CommandContext(ctx context.Context, cmd string, args ...string) utilexec.Cmd {
+	return &tracedCmd{
+		Cmd:    e.Interface.CommandContext(ctx, cmd, args...),
+		tracer: e.tracer,
+		ctx:    ctx,
+		argv:   append([]string{cmd}, args...),
+	}
+}
+
+func (e *tracedExec) withParent(ctx context.Context) *tracedExec {
+	return &tracedExec{
+		Interface: e.Interface,
+		tracer:    e.tracer,
+		parent:    ctx,
+	}
+}
+
+// tracedCmd records a span from the start of a command to its exit
+type tracedCmd struct {
+	utilexec.Cmd
+	tracer trace.Tracer
+	ctx    context.Context
+	argv   []string
+	// span is the span of a command started and not waited for yet
+	span trace.Span
+}
+
+func (c *tracedCmd) Run() error {
+	span := c.start()
+	err := c.Cmd.Run()
+	endCommandSpan(span, err)
+	return err
+}
+
+func (c *tracedCmd) CombinedOutput() ([]byte, error) {
+	span := c.start()
+	out, err := c.Cmd.CombinedOutput()
+	endCommandSpan(span, err)
+	return out, err
+}
+
+func (c *tracedCmd) Output() ([]byte, error) {
+	span := c.start()
+	out, err := c.Cmd.Output()
+	endCommandSpan(span, err)
+	return out, err
+}
+
+func (c *tracedCmd) Start() error {
+	c.span = c.start()
+	err := c.Cmd.Start()
+	if err != nil {
+		endCommandSpan(c.span, err)
+		c.span = nil
+	}
+	return err
+}
+
+func (c *tracedCmd) Wait() error {
+	err := c.Cmd.Wait()
+	if c.span != nil {
+		endCommandSpan(c.span, err)
+		c.span = nil
+	}
+	return err
+}
+
+func (c *tracedCmd) start() trace.Span {
+	return startCommandSpan(c.ctx, c.tracer, c.argv)
+}
+
+// startCommandSpan starts the span of the command of argv
+func startCommandSpan(ctx context.Context, tracer trace.Tracer, argv []string) trace.Span {
+	_, span := tracer.Start(ctx, argv[0], trace.WithAttributes(
+		semconv.ProcessExecutableNameKey.String(argv[0]),
+		semconv.ProcessCommandArgsKey.StringSlice(argv),
+	))
+	return span
+}
+
+// endCommandSpan ends the span of a command that exited with err
+func endCommandSpan(span trace.Span, err error) {
+	defer span.End()
+
+	var exitErr utilexec.ExitError
+	switch {
+	case err == nil:
+		span.SetAttributes(exitCodeKey.Int(0))
+	case errors.As(err, &exitErr):
+		span.SetAttributes(exitCodeKey.Int(exitErr.ExitStatus()))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/mount-utils"
+)
+
+// tracedMounter records a span for each mount and umount command of a
+// mounter, as child spans of parent. mount-utils runs them itself rather
+// than through an exec.Interface and reports no exit code of failures.
+type tracedMounter struct {
+	mount.Interface
+	tracer trace.Tracer
+	parent context.Context
+}
+
+var _ mount.Interface = &tracedMounter{}
+
+func (m *tracedMounter) Mount(source string, target string, fstype string, options []string) error {
+	span := m.start(source, target, fstype, options)
+	err := m.Interface.Mount(source, target, fstype, options)
+	endCommandSpan(span, err)
+	return err
+}
+
+func (m *tracedMounter) MountSensitive(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	span := m.start(source, target, fstype, options)
+	err := m.Interface.MountSensitive(source, target, fstype, options, sensitiveOptions)
+	endCommandSpan(span, err)
+	return err
+}
+
+func (m *tracedMounter) MountSensitiveWithoutSystemd(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	span := m.start(source, target, fstype, options)
+	err := m.Interface.MountSensitiveWithoutSystemd(source, target, fstype, options, sensitiveOptions)
+	endCommandSpan(span, err)
+	return err
+}
+
+func (m *tracedMounter) MountSensitiveWithoutSystemdWithMountFlags(source string, target string, fstype string, options []string, sensitiveOptions []string, mountFlags []string) error {
+	span := m.start(source, target, fstype, options)
+	err := m.Interface.MountSensitiveWithoutSystemdWithMountFlags(source, target, fstype, options, sensitiveOptions, mountFlags)
+	endCommandSpan(span, err)
+	return err
+}
+
+func (m *tracedMounter) Unmount(target string) error {
+	span := startCommandSpan(m.parent, m.tracer, []string{"umount", target})
+	err := m.Interface.Unmount(target)
+	endCommandSpan(span, err)
+	return err
+}
+
+// start starts the span of a mount command. Sensitive options are left out.
+func (m *tracedMounter) start(source string, target string, fstype string, options []string) trace.Span {
+	argv := append([]string{"mount"}, mount.MakeMountArgs(source, target, fstype, options)...)
+	return startCommandSpan(m.parent, m.tracer, argv)
+}
+
+func (m *tracedMounter) withParent(ctx context.Context) *tracedMounter {
+	return &tracedMounter{
+		Interface: m.Interface,
+		tracer:    m.tracer,
+		parent:    ctx,
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing exports OpenTelemetry traces of the driver: a span for each
+// CSI RPC, continuing the trace of the sidecar calling it, with a child span
+// for each external command the RPC runs.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+// instrumentationName names the tracer of the driver
+const instrumentationName = "github.com/openshift/lvm-driver"
+
+// volumeIDKey is the attribute holding the volume of a CSI RPC
+const volumeIDKey = attribute.Key("csi.volume.id")
+
+// Options configure the export of traces
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC collector to export spans
+	// to, empty to disable tracing
+	Endpoint string
+	// Insecure exports spans without TLS
+	Insecure bool
+	// SamplingRatio is the fraction of the RPCs sampled when the caller did
+	// not sample them already, from 0 to 1
+	SamplingRatio float64
+}
+
+// Tracing records the spans of the driver and exports them
+type Tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New returns tracing exporting spans to the collector of options, naming the
+// driver service and its node in the resource of the spans. The collector is
+// connected to in the background, it need not be up yet.
+func New(service string, version string, nodeID string, options Options) (*Tracing, error) {
+	if options.Endpoint == "" {
+		return nil, fmt.Errorf("an OTLP endpoint is needed to export traces")
+	}
+
+	if options.SamplingRatio < 0 || options.SamplingRatio > 1 {
+		return nil, fmt.Errorf("tracing sampling ratio %v is not between 0 and 1", options.SamplingRatio)
+	}
+
+	exporterOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background(), exporterOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter of %s: %v", options.Endpoint, err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(service),
+		semconv.ServiceVersionKey.String(version),
+		semconv.K8SNodeNameKey.String(nodeID),
+	)
+
+	// Sidecars sampling a call have its commands sampled too
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SamplingRatio))
+
+	return newTracing(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)), nil
+}
+
+func newTracing(provider *sdktrace.TracerProvider) *Tracing {
+	return &Tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// Shutdown exports the spans still buffered and stops exporting
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// UnaryInterceptor records a server span for each RPC, continuing the trace
+// propagated by the caller in the gRPC metadata, if any
+func (t *Tracing) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = t.propagator.Extract(ctx, metadataCarrier(md))
+
+	// Full methods are /<service>/<method>
+	name := strings.TrimPrefix(info.FullMethod, "/")
+	service, method, _ := strings.Cut(name, "/")
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemGRPC,
+		semconv.RPCServiceKey.String(service),
+		semconv.RPCMethodKey.String(method),
+	}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		attrs = append(attrs, volumeIDKey.String(r.GetVolumeId()))
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+
+	return resp, err
+}
+
+// Exec returns an exec.Interface running the commands of exec in child spans
+// of the span of their context
+func (t *Tracing) Exec(exec utilexec.Interface) utilexec.Interface {
+	return &tracedExec{
+		Interface: exec,
+		tracer:    t.tracer,
+		parent:    context.Background(),
+	}
+}
+
+// Mounter returns a mount.Interface running the mount commands of mounter in
+// spans of their own
+func (t *Tracing) Mounter(mounter mount.Interface) mount.Interface {
+	return &tracedMounter{
+		Interface: mounter,
+		tracer:    t.tracer,
+		parent:    context.Background(),
+	}
+}
+
+// WithParent returns the mounter with the commands it runs as child spans of
+// the span of ctx, given mount-utils runs them without a context. Neither the
+// commands nor the mounter depend on ctx otherwise. Mounters not traced are
+// returned as they are.
+func WithParent(ctx context.Context, mounter *mount.SafeFormatAndMount) *mount.SafeFormatAndMount {
+	exec, tracedExec := mounter.Exec.(*tracedExec)
+	mnt, tracedMnt := mounter.Interface.(*tracedMounter)
+	if !tracedExec && !tracedMnt {
+		return mounter
+	}
+
+	parented := *mounter
+	if tracedExec {
+		parented.Exec = exec.withParent(ctx)
+	}
+	if tracedMnt {
+		parented.Interface = mnt.withParent(ctx)
+	}
+	return &parented
+}
+
+// metadataCarrier reads and writes the propagated trace context in the
+// metadata of a gRPC call
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
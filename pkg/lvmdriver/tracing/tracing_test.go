@@ -0,0 +1,281 @@
+/*
+Copyright © 2023 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+)
+
+// newTestTracing returns tracing recording every span in memory
+func newTestTracing() (*Tracing, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return newTracing(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))), exporter
+}
+
+// spanAttributes returns the attributes of a span by key
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		desc      string
+		options   Options
+		expectErr bool
+	}{
+		{
+			desc:    "insecure collector",
+			options: Options{Endpoint: "localhost:4317", Insecure: true, SamplingRatio: 1},
+		},
+		{
+			desc:    "collector with TLS",
+			options: Options{Endpoint: "collector.monitoring:4317", SamplingRatio: 0.1},
+		},
+		{
+			desc:      "missing endpoint",
+			options:   Options{SamplingRatio: 1},
+			expectErr: true,
+		},
+		{
+			desc:      "sampling ratio above 1",
+			options:   Options{Endpoint: "localhost:4317", SamplingRatio: 2},
+			expectErr: true,
+		},
+		{
+			desc:      "negative sampling ratio",
+			options:   Options{Endpoint: "localhost:4317", SamplingRatio: -0.5},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tracing, err := New("lvm.redhat.com", "v0.1.0", "node_001", test.options)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			// The collector is only connected to in the background
+			assert.NoError(t, err)
+			assert.NoError(t, tracing.Shutdown(context.Background()))
+		})
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	// The trace context of a sidecar sampling the call
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	tests := []struct {
+		desc         string
+		md           metadata.MD
+		req          interface{}
+		err          error
+		expectRemote bool
+		expectVolume string
+	}{
+		{
+			desc:         "propagated trace",
+			md:           metadata.Pairs("traceparent", traceparent),
+			req:          &csi.DeleteVolumeRequest{VolumeId: "vg0/pvc-1"},
+			expectRemote: true,
+			expectVolume: "vg0/pvc-1",
+		},
+		{
+			desc: "new trace",
+			req:  &csi.CreateVolumeRequest{Name: "pvc-1"},
+		},
+		{
+			desc: "failure",
+			req:  &csi.CreateVolumeRequest{Name: "pvc-1"},
+			err:  status.Error(codes.ResourceExhausted, "volume group vg0 is full"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tracing, exporter := newTestTracing()
+
+			ctx := context.Background()
+			if test.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, test.md)
+			}
+
+			var handlerSpan trace.SpanContext
+			_, err := tracing.UnaryInterceptor(ctx, test.req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerSpan = trace.SpanContextFromContext(ctx)
+				return nil, test.err
+			})
+			assert.Equal(t, test.err, err)
+
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 1)
+			span := spans[0]
+
+			// The handler runs in the span of the RPC
+			assert.Equal(t, span.SpanContext, handlerSpan)
+			assert.Equal(t, "csi.v1.Controller/CreateVolume", span.Name)
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+			assert.Equal(t, test.expectRemote, span.Parent.IsRemote())
+			if test.expectRemote {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+			}
+
+			attrs := spanAttributes(span)
+			assert.Equal(t, "grpc", attrs["rpc.system"].AsString())
+			assert.Equal(t, "csi.v1.Controller", attrs["rpc.service"].AsString())
+			assert.Equal(t, "CreateVolume", attrs["rpc.method"].AsString())
+			assert.Equal(t, int64(status.Code(test.err)), attrs["rpc.grpc.status_code"].AsInt64())
+			assert.Equal(t, test.expectVolume, attrs[volumeIDKey].AsString())
+
+			if test.err != nil {
+				assert.Equal(t, otelcodes.Error, span.Status.Code)
+				assert.Len(t, span.Events, 1)
+			} else {
+				assert.Equal(t, otelcodes.Unset, span.Status.Code)
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	tests := []struct {
+		desc             string
+		cmd              string
+		args             []string
+		expectedExitCode int64
+		expectErr        bool
+	}{
+		{
+			desc: "success",
+			cmd:  "true",
+		},
+		{
+			desc:             "exit code",
+			cmd:              "sh",
+			args:             []string{"-c", "exit 5"},
+			expectedExitCode: 5,
+			expectErr:        true,
+		},
+		{
+			desc:             "missing command",
+			cmd:              "lvm-driver-missing-command",
+			expectedExitCode: -1,
+			expectErr:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tracing, exporter := newTestTracing()
+
+			ctx, parent := tracing.tracer.Start(context.Background(), "csi.v1.Controller/CreateVolume")
+			err := tracing.Exec(exec.New()).CommandContext(ctx, test.cmd, test.args...).Run()
+			parent.End()
+			assert.Equal(t, test.expectErr, err != nil)
+
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, 2)
+			span := spans[0]
+
+			assert.Equal(t, test.cmd, span.Name)
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+
+			attrs := spanAttributes(span)
+			assert.Equal(t, append([]string{test.cmd}, test.args...), attrs["process.command_args"].AsStringSlice())
+			if test.expectedExitCode >= 0 {
+				assert.Equal(t, test.expectedExitCode, attrs[exitCodeKey].AsInt64())
+			} else {
+				// Commands that did not start have no exit code
+				assert.NotContains(t, attrs, exitCodeKey)
+			}
+			if test.expectErr {
+				assert.Equal(t, otelcodes.Error, span.Status.Code)
+			}
+		})
+	}
+}
+
+func TestExecStartWait(t *testing.T) {
+	tracing, exporter := newTestTracing()
+
+	cmd := tracing.Exec(exec.New()).Command("sh", "-c", "exit 2")
+	assert.NoError(t, cmd.Start())
+	assert.Empty(t, exporter.GetSpans())
+	assert.Error(t, cmd.Wait())
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, int64(2), spanAttributes(spans[0])[exitCodeKey].AsInt64())
+}
+
+func TestWithParent(t *testing.T) {
+	tracing, exporter := newTestTracing()
+	mounter := mount.NewSafeFormatAndMount(tracing.Mounter(mount.NewFakeMounter(nil)), tracing.Exec(exec.New()))
+
+	ctx, parent := tracing.tracer.Start(context.Background(), "csi.v1.Node/NodePublishVolume")
+	parented := WithParent(ctx, mounter)
+	assert.NoError(t, parented.Mount("/dev/vg0/pvc-1", "/target", "", []string{"bind", "ro"}))
+	assert.NoError(t, parented.Unmount("/target"))
+	_, err := parented.Exec.Command("true").CombinedOutput()
+	assert.NoError(t, err)
+	parent.End()
+
+	// The mounter given stays without a parent
+	assert.NoError(t, mounter.Mount("/dev/vg0/pvc-2", "/other", "", nil))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 5)
+
+	expectedArgs := [][]string{
+		{"mount", "-o", "bind,ro", "/dev/vg0/pvc-1", "/target"},
+		{"umount", "/target"},
+		{"true"},
+	}
+	for i, args := range expectedArgs {
+		assert.Equal(t, args, spanAttributes(spans[i])["process.command_args"].AsStringSlice(), fmt.Sprintf("span %d", i))
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[i].Parent.SpanID(), fmt.Sprintf("span %d", i))
+	}
+	assert.False(t, spans[4].Parent.IsValid())
+
+	// Mounters not traced are left as they are
+	untraced := mount.NewSafeFormatAndMount(mount.NewFakeMounter(nil), exec.New())
+	assert.Same(t, untraced, WithParent(ctx, untraced))
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultCopyBlockSize is the size of the reads and writes of a BlockCopier
+const defaultCopyBlockSize = 1 << 20
+
+// Copier copies the contents of one device to another
+type Copier interface {
+	// Copy copies size bytes from the start of src to the start of dst
+	Copy(ctx context.Context, src string, dst string, size int64) error
+}
+
+// NopCopier copies nothing, for devices without content like the volumes of
+// the in-memory lvm backend
+type NopCopier struct{}
+
+var _ Copier = NopCopier{}
+
+func (NopCopier) Copy(ctx context.Context, src string, dst string, size int64) error {
+	return nil
+}
+
+// BlockCopier copies devices block by block like dd, never exceeding its bandwidth
+type BlockCopier struct {
+	// bytesPerSecond limits the bandwidth of a copy, 0 leaves it unbounded
+	bytesPerSecond int64
+	blockSize      int
+}
+
+var _ Copier = &BlockCopier{}
+
+func NewBlockCopier(bytesPerSecond int64) *BlockCopier {
+	return &BlockCopier{
+		bytesPerSecond: bytesPerSecond,
+		blockSize:      defaultCopyBlockSize,
+	}
+}
+
+func (c *BlockCopier) Copy(ctx context.Context, src string, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", dst, err)
+	}
+	defer out.Close()
+
+	buf := make([]byte, c.blockSize)
+	start := time.Now()
+	var copied int64
+
+	for copied < size {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("copy of %s to %s interrupted after %d bytes: %v", src, dst, copied, err)
+		}
+
+		block := buf
+		if remaining := size - copied; remaining < int64(len(block)) {
+			block = block[:remaining]
+		}
+
+		n, err := io.ReadFull(in, block)
+		if err != nil {
+			return fmt.Errorf("failed to read %s at %d: %v", src, copied, err)
+		}
+
+		if _, err := out.Write(block[:n]); err != nil {
+			return fmt.Errorf("failed to write %s at %d: %v", dst, copied, err)
+		}
+		copied += int64(n)
+
+		if err := c.throttle(ctx, copied, time.Since(start)); err != nil {
+			return fmt.Errorf("copy of %s to %s interrupted after %d bytes: %v", src, dst, copied, err)
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", dst, err)
+	}
+
+	return nil
+}
+
+// throttle waits until copying the bytes so far has taken as long as the bandwidth allows
+func (c *BlockCopier) throttle(ctx context.Context, copied int64, elapsed time.Duration) error {
+	if c.bytesPerSecond <= 0 {
+		return nil
+	}
+
+	expected := time.Duration(float64(copied) / float64(c.bytesPerSecond) * float64(time.Second))
+	if expected <= elapsed {
+		return nil
+	}
+
+	timer := time.NewTimer(expected - elapsed)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDevice writes a file standing in for a block device
+func writeDevice(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, content, 0600))
+	return path
+}
+
+func TestBlockCopierCopy(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+
+	tests := []struct {
+		desc      string
+		size      int64
+		blockSize int
+		expectErr bool
+	}{
+		{
+			desc:      "whole blocks",
+			size:      int64(len(content)),
+			blockSize: 4096,
+		},
+		{
+			desc:      "partial last block",
+			size:      int64(len(content)) - 100,
+			blockSize: 4096,
+		},
+		{
+			desc:      "past the end of the source",
+			size:      int64(len(content)) + 1,
+			blockSize: 4096,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			src := writeDevice(t, "src", content)
+			dst := writeDevice(t, "dst", make([]byte, len(content)+4096))
+
+			copier := NewBlockCopier(0)
+			copier.blockSize = test.blockSize
+
+			err := copier.Copy(context.Background(), src, dst, test.size)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			copied, err := os.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, content[:test.size], copied[:test.size])
+			assert.Equal(t, make([]byte, len(copied)-int(test.size)), copied[test.size:], "the rest of the destination should be left alone")
+		})
+	}
+}
+
+func TestBlockCopierBandwidth(t *testing.T) {
+	src := writeDevice(t, "src", make([]byte, 64<<10))
+	dst := writeDevice(t, "dst", make([]byte, 64<<10))
+
+	// 64KiB at 256KiB/s takes at least 250ms
+	copier := NewBlockCopier(256 << 10)
+	copier.blockSize = 16 << 10
+
+	start := time.Now()
+	assert.NoError(t, copier.Copy(context.Background(), src, dst, 64<<10))
+	assert.GreaterOrEqual(t, time.Since(start), 240*time.Millisecond)
+}
+
+func TestBlockCopierCancel(t *testing.T) {
+	src := writeDevice(t, "src", make([]byte, 64<<10))
+	dst := writeDevice(t, "dst", make([]byte, 64<<10))
+
+	copier := NewBlockCopier(1 << 10)
+	copier.blockSize = 1 << 10
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorContains(t, copier.Copy(ctx, src, dst, 64<<10), "interrupted")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -2,8 +2,11 @@ package utils
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc"
@@ -20,20 +23,54 @@ func ParseEndpoint(ep string) (string, string, error) {
 	return "", "", fmt.Errorf("invalid endpoint: %v", ep)
 }
 
+// GRPCLogger logs each call with its request and response. It assigns the
+// call a request ID and hands the handler a context logger carrying it along
+// with the method and the volume and node of the request, so the messages
+// of concurrent calls can be told apart.
 func GRPCLogger(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	level := klog.Level(getLogLevel(info.FullMethod))
-	klog.V(level).Infof("GRPC call: %s", info.FullMethod)
-	klog.V(level).Infof("GRPC request: %s", protosanitizer.StripSecrets(req))
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), requestValues(info.FullMethod, req)...)
+	ctx = klog.NewContext(ctx, logger)
 
+	level := int(getLogLevel(info.FullMethod))
+	logger.V(level).Info("GRPC call", "request", protosanitizer.StripSecrets(req))
+
+	start := time.Now()
 	resp, err := handler(ctx, req)
 	if err != nil {
-		klog.Errorf("GRPC error: %v", err)
+		logger.Error(err, "GRPC error", "duration", time.Since(start))
 	} else {
-		klog.V(level).Infof("GRPC response: %s", protosanitizer.StripSecrets(resp))
+		logger.V(level).Info("GRPC response", "response", protosanitizer.StripSecrets(resp), "duration", time.Since(start))
 	}
 	return resp, err
 }
 
+// requestValues returns the key-values identifying a call and the volume and
+// node of its request, if it has any
+func requestValues(method string, req interface{}) []interface{} {
+	values := []interface{}{"request_id", newRequestID(), "method", method}
+
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		values = append(values, "volume_id", r.GetVolumeId())
+	}
+
+	if r, ok := req.(interface{ GetNodeId() string }); ok && r.GetNodeId() != "" {
+		values = append(values, "node_id", r.GetNodeId())
+	}
+
+	return values
+}
+
+// newRequestID returns a random ID for a call
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// Logging must not fail a call, the method and volume still identify it
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id)
+}
+
 func getLogLevel(method string) int32 {
 	if method == "/csi.v1.Identity/Probe" ||
 		method == "/csi.v1.Node/NodeGetCapabilities" ||
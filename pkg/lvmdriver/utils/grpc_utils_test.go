@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"context"
+	"fmt"
+	"regexp"
 	"testing"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/go-logr/logr/funcr"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
 )
 
 func TestParseEndpoint(t *testing.T) {
@@ -97,3 +104,78 @@ func TestGetLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestValues(t *testing.T) {
+	tests := []struct {
+		desc           string
+		req            interface{}
+		expectedValues map[string]interface{}
+	}{
+		{
+			desc: "volume request",
+			req:  &csi.NodePublishVolumeRequest{VolumeId: "vg0/pvc-1"},
+			expectedValues: map[string]interface{}{
+				"method":    "/csi.v1.Node/NodePublishVolume",
+				"volume_id": "vg0/pvc-1",
+			},
+		},
+		{
+			desc: "volume and node request",
+			req:  &csi.ControllerPublishVolumeRequest{VolumeId: "vg0/pvc-1", NodeId: "node_001"},
+			expectedValues: map[string]interface{}{
+				"method":    "/csi.v1.Node/NodePublishVolume",
+				"volume_id": "vg0/pvc-1",
+				"node_id":   "node_001",
+			},
+		},
+		{
+			desc: "request without a volume",
+			req:  &csi.NodeGetInfoRequest{},
+			expectedValues: map[string]interface{}{
+				"method": "/csi.v1.Node/NodePublishVolume",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			values := map[string]interface{}{}
+			kvs := requestValues("/csi.v1.Node/NodePublishVolume", test.req)
+			for i := 0; i < len(kvs); i += 2 {
+				values[kvs[i].(string)] = kvs[i+1]
+			}
+
+			assert.Len(t, values["request_id"], 16)
+			delete(values, "request_id")
+			assert.Equal(t, test.expectedValues, values)
+		})
+	}
+}
+
+func TestGRPCLogger(t *testing.T) {
+	var lines []string
+	logger := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{})
+	ctx := klog.NewContext(context.Background(), logger)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	req := &csi.NodeStageVolumeRequest{VolumeId: "vg0/pvc-1"}
+	_, err := GRPCLogger(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		klog.FromContext(ctx).Info("mounting device")
+		return nil, fmt.Errorf("mount failed")
+	})
+	assert.EqualError(t, err, "mount failed")
+
+	// The handler logs with the values of the call, as does the logger on errors
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Contains(t, line, `"method"="/csi.v1.Node/NodeStageVolume"`)
+		assert.Contains(t, line, `"volume_id"="vg0/pvc-1"`)
+	}
+	assert.Contains(t, lines[1], `"error"="mount failed"`)
+
+	requestID := regexp.MustCompile(`"request_id"="([0-9a-f]{16})"`)
+	assert.Regexp(t, requestID, lines[0])
+	assert.Equal(t, requestID.FindString(lines[0]), requestID.FindString(lines[1]))
+}
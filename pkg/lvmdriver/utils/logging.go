@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TextLoggingFormat is klog's own line based format
+	TextLoggingFormat = "text"
+	// JSONLoggingFormat writes a JSON object per message with its key-values as fields
+	JSONLoggingFormat = "json"
+)
+
+// SetLoggingFormat makes klog write its messages to w in format. The text
+// format leaves klog as configured by its flags.
+func SetLoggingFormat(format string, w io.Writer) error {
+	switch format {
+	case TextLoggingFormat:
+		return nil
+	case JSONLoggingFormat:
+		logger := funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(w, obj)
+		}, funcr.Options{
+			LogCaller:    funcr.All,
+			LogTimestamp: true,
+			// klog only hands over messages enabled by -v and -vmodule
+			Verbosity: math.MaxInt32,
+		})
+		klog.SetLogger(logr.New(trimmedSink{logger.GetSink()}))
+		return nil
+	default:
+		return fmt.Errorf("unsupported logging format %q, expected %s or %s", format, TextLoggingFormat, JSONLoggingFormat)
+	}
+}
+
+// trimmedSink drops the newline klog ends the messages of its printf style
+// functions with, which JSON would keep escaped in the message
+type trimmedSink struct {
+	logr.LogSink
+}
+
+func (s trimmedSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.LogSink.Info(level, strings.TrimSuffix(msg, "\n"), keysAndValues...)
+}
+
+func (s trimmedSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.LogSink.Error(err, strings.TrimSuffix(msg, "\n"), keysAndValues...)
+}
+
+func (s trimmedSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return trimmedSink{s.LogSink.WithValues(keysAndValues...)}
+}
+
+func (s trimmedSink) WithName(name string) logr.LogSink {
+	return trimmedSink{s.LogSink.WithName(name)}
+}
+
+func (s trimmedSink) WithCallDepth(depth int) logr.LogSink {
+	if sink, ok := s.LogSink.(logr.CallDepthLogSink); ok {
+		return trimmedSink{sink.WithCallDepth(depth)}
+	}
+	return s
+}
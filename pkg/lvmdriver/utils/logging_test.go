@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2"
+)
+
+func TestSetLoggingFormat(t *testing.T) {
+	tests := []struct {
+		desc      string
+		format    string
+		expectLog bool
+		expectErr bool
+	}{
+		{
+			desc:   "text",
+			format: TextLoggingFormat,
+		},
+		{
+			desc:      "json",
+			format:    JSONLoggingFormat,
+			expectLog: true,
+		},
+		{
+			desc:      "unsupported format",
+			format:    "yaml",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			defer klog.ClearLogger()
+
+			var out bytes.Buffer
+			err := SetLoggingFormat(test.format, &out)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			klog.Infof("mounting device %s", "/dev/vg0/pvc-1")
+			klog.InfoS("mounting device", "volume_id", "vg0/pvc-1")
+			if !test.expectLog {
+				assert.Empty(t, out.String())
+				return
+			}
+
+			var entries []map[string]interface{}
+			decoder := json.NewDecoder(&out)
+			for decoder.More() {
+				var entry map[string]interface{}
+				assert.NoError(t, decoder.Decode(&entry))
+				entries = append(entries, entry)
+			}
+
+			assert.Len(t, entries, 2)
+			assert.Equal(t, "mounting device /dev/vg0/pvc-1", entries[0]["msg"])
+			assert.Equal(t, "mounting device", entries[1]["msg"])
+			assert.Equal(t, "vg0/pvc-1", entries[1]["volume_id"])
+			assert.Contains(t, entries[1]["caller"], "file")
+		})
+	}
+}
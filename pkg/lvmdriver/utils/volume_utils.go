@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ParseVolumeID splits a volume ID of the form <vg>/<lv> into the
+// volume group and logical volume names
+func ParseVolumeID(id string) (string, string, error) {
+	s := strings.Split(id, "/")
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", fmt.Errorf("invalid volume id: %v", id)
+	}
+	return s[0], s[1], nil
+}
+
+// VolumeID formats the ID of the logical volume of a volume group, the
+// inverse of ParseVolumeID
+func VolumeID(vg string, lv string) string {
+	return vg + "/" + lv
+}
+
+// DevicePath returns the device node LVM creates for a logical volume
+func DevicePath(vg string, lv string) string {
+	return filepath.Join("/dev", vg, lv)
+}
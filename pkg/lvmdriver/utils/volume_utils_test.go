@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeID(t *testing.T) {
+	tests := []struct {
+		desc      string
+		id        string
+		vg        string
+		lv        string
+		expectErr bool
+	}{
+		{
+			desc: "valid volume id",
+			id:   "vg0/pvc-1234",
+			vg:   "vg0",
+			lv:   "pvc-1234",
+		},
+		{
+			desc:      "missing volume group",
+			id:        "/pvc-1234",
+			expectErr: true,
+		},
+		{
+			desc:      "missing logical volume",
+			id:        "vg0/",
+			expectErr: true,
+		},
+		{
+			desc:      "no separator",
+			id:        "pvc-1234",
+			expectErr: true,
+		},
+		{
+			desc:      "too many separators",
+			id:        "vg0/pvc/1234",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			vg, lv, err := ParseVolumeID(test.id)
+
+			if test.expectErr {
+				assert.Error(t, err, "no error detected when one was expected")
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.vg, vg)
+			assert.Equal(t, test.lv, lv)
+		})
+	}
+}
+
+func TestDevicePath(t *testing.T) {
+	assert.Equal(t, "/dev/vg0/pvc-1234", DevicePath("vg0", "pvc-1234"))
+}